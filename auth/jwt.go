@@ -7,12 +7,24 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func GenerateJWT(userID string) (string, error) {
+// AccessTokenTTL is how long an access token is valid for. They are short
+// lived as they can't be revoked; clients use a refresh token to get a new one.
+const AccessTokenTTL = 15 * time.Minute
+
+// GenerateJWT issues an access token for a user's session, returning it with
+// its expiry time. The session ID is the token's jti claim, so every token
+// from one login can be revoked together.
+func GenerateJWT(userID, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL)
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 28).Unix(), // Token valid for 24 hours
+		"jti":     sessionID,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	secret := os.Getenv("JWT_SECRET")
-	return token.SignedString([]byte(secret))
+	signed, err := token.SignedString([]byte(secret))
+	return signed, expiresAt, err
 }
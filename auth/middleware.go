@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"receipt-splitter-backend/helpers"
@@ -13,52 +14,102 @@ import (
 // UserIDKey is the context key for the authenticated user's ID
 type UserIDKey struct{}
 
-// JWTMiddleware validates the JWT token and adds the user ID to the request context
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Authorization header missing")
-			return
-		}
+// authError is a token validation failure with the status to respond with
+type authError struct {
+	status  int
+	message string
+}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Invalid Authorization header format")
-			return
-		}
+func (e *authError) Error() string {
+	return e.message
+}
 
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Server misconfigured: JWT secret missing")
-			return
+// userIDFromRequest validates the bearer token on a request and returns its
+// user ID and session ID
+func userIDFromRequest(r *http.Request) (string, string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", "", &authError{http.StatusUnauthorized, "Authorization header missing"}
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return "", "", &authError{http.StatusUnauthorized, "Invalid Authorization header format"}
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", "", &authError{http.StatusInternalServerError, "Server misconfigured: JWT secret missing"}
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, http.ErrUseLastResponse
 		}
+		return []byte(secret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", &authError{http.StatusUnauthorized, "Invalid token"}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", &authError{http.StatusUnauthorized, "Invalid token claims"}
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return "", "", &authError{http.StatusUnauthorized, "Invalid token payload"}
+	}
+	sessionID, ok := claims["jti"].(string)
+	if !ok {
+		return "", "", &authError{http.StatusUnauthorized, "Invalid token payload"}
+	}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrUseLastResponse
+	active, err := sessionActive(sessionID, userID)
+	if err != nil {
+		return "", "", &authError{http.StatusInternalServerError, "Failed to check session"}
+	}
+	if !active {
+		return "", "", &authError{http.StatusUnauthorized, "Session has been revoked"}
+	}
+
+	return userID, sessionID, nil
+}
+
+// withUser adds the user and session IDs to a request's context
+func withUser(r *http.Request, userID, sessionID string) *http.Request {
+	ctx := context.WithValue(r.Context(), UserIDKey{}, userID)
+	ctx = context.WithValue(ctx, SessionIDKey{}, sessionID)
+	return r.WithContext(ctx)
+}
+
+// JWTMiddleware validates the JWT token and adds the user ID to the request context
+func JWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, sessionID, err := userIDFromRequest(r)
+		if err != nil {
+			var authErr *authError
+			if errors.As(err, &authErr) {
+				helpers.JSONErrorResponse(w, authErr.status, authErr.message)
+				return
 			}
-			return []byte(secret), nil
-		})
-		if err != nil || !token.Valid {
 			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Invalid token claims")
-			return
-		}
+		next.ServeHTTP(w, withUser(r, userID, sessionID))
+	})
+}
 
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Invalid token payload")
-			return
+// OptionalJWTMiddleware adds the user ID to the request context when a valid
+// token is present, and lets the request through anonymously otherwise
+func OptionalJWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, sessionID, err := userIDFromRequest(r); err == nil {
+			r = withUser(r, userID, sessionID)
 		}
-
-		ctx := context.WithValue(r.Context(), UserIDKey{}, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestUserIDFromRequest(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	sign := func(secret string, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		return token
+	}
+	valid, _, err := GenerateJWT("alice", "session")
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	tests := []struct {
+		name        string
+		header      string
+		wantUser    string
+		wantSession string
+		wantErr     bool
+	}{
+		{"valid", "Bearer " + valid, "alice", "session", false},
+		{"missing header", "", "", "", true},
+		{"not a bearer token", valid, "", "", true},
+		{"other secret", "Bearer " + sign("other-secret", jwt.MapClaims{"user_id": "alice", "jti": "session", "exp": exp}), "", "", true},
+		{"expired", "Bearer " + sign("test-secret", jwt.MapClaims{"user_id": "alice", "jti": "session", "exp": time.Now().Add(-time.Minute).Unix()}), "", "", true},
+		{"no session", "Bearer " + sign("test-secret", jwt.MapClaims{"user_id": "alice", "exp": exp}), "", "", true},
+		{"no user", "Bearer " + sign("test-secret", jwt.MapClaims{"jti": "session", "exp": exp}), "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			userID, sessionID, err := userIDFromRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("userIDFromRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if userID != tt.wantUser || sessionID != tt.wantSession {
+				t.Errorf("userIDFromRequest() = %q, %q, want %q, %q", userID, sessionID, tt.wantUser, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestGenerateJWTExpiry(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	before := time.Now()
+	_, expiresAt, err := GenerateJWT("alice", "session")
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	if expiresAt.Before(before.Add(AccessTokenTTL)) || expiresAt.After(time.Now().Add(AccessTokenTTL)) {
+		t.Errorf("token expires at %v, want %v after issue", expiresAt, AccessTokenTTL)
+	}
+}
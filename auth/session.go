@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SessionIDKey is the context key for the session of the request's token
+type SessionIDKey struct{}
+
+// SessionCheck reports whether a user's session is still active
+type SessionCheck func(sessionID, userID string) (bool, error)
+
+// sessionCacheTTL bounds how long a revoked session may keep working on
+// another server that cached it as active
+const sessionCacheTTL = 30 * time.Second
+
+// maxCachedSessions is when expired cache entries are swept out
+const maxCachedSessions = 10000
+
+type cachedSession struct {
+	userID string
+	active bool
+	until  time.Time
+}
+
+var (
+	checkSession SessionCheck
+	sessionMu    sync.Mutex
+	sessionCache = map[string]cachedSession{}
+)
+
+// InitSessions makes the middleware check every token's session, so
+// revoked sessions stop working before their access tokens expire
+func InitSessions(check SessionCheck) {
+	checkSession = check
+}
+
+// ForgetSession drops a session from the cache, e.g. once it's revoked
+func ForgetSession(sessionID string) {
+	sessionMu.Lock()
+	delete(sessionCache, sessionID)
+	sessionMu.Unlock()
+}
+
+// sessionActive checks a session, using the cache where it can
+func sessionActive(sessionID, userID string) (bool, error) {
+	if checkSession == nil {
+		return true, nil
+	}
+
+	now := time.Now()
+	sessionMu.Lock()
+	cached, ok := sessionCache[sessionID]
+	sessionMu.Unlock()
+	if ok && cached.userID == userID && now.Before(cached.until) {
+		return cached.active, nil
+	}
+
+	active, err := checkSession(sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	sessionMu.Lock()
+	if len(sessionCache) >= maxCachedSessions {
+		for k, v := range sessionCache {
+			if now.After(v.until) {
+				delete(sessionCache, k)
+			}
+		}
+	}
+	sessionCache[sessionID] = cachedSession{userID: userID, active: active, until: now.Add(sessionCacheTTL)}
+	sessionMu.Unlock()
+	return active, nil
+}
+
+// GetSessionIDFromContext retrieves the session ID from the request context
+func GetSessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(SessionIDKey{}).(string)
+	return sessionID, ok
+}
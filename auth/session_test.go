@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeSessions is a session store that counts lookups
+type fakeSessions struct {
+	active map[string]string // Session ID to user ID
+	err    error
+	calls  int
+}
+
+func (f *fakeSessions) check(sessionID, userID string) (bool, error) {
+	f.calls++
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.active[sessionID] == userID, nil
+}
+
+func useSessions(t *testing.T, f *fakeSessions) {
+	t.Helper()
+	InitSessions(f.check)
+	t.Cleanup(func() {
+		InitSessions(nil)
+		sessionMu.Lock()
+		sessionCache = map[string]cachedSession{}
+		sessionMu.Unlock()
+	})
+}
+
+func TestSessionActiveCaches(t *testing.T) {
+	f := &fakeSessions{active: map[string]string{"s1": "alice"}}
+	useSessions(t, f)
+
+	for i := 0; i < 3; i++ {
+		active, err := sessionActive("s1", "alice")
+		if err != nil || !active {
+			t.Fatalf("sessionActive() = %v, %v, want true", active, err)
+		}
+	}
+	if f.calls != 1 {
+		t.Errorf("store checked %d times, want 1", f.calls)
+	}
+}
+
+func TestSessionActiveOtherUser(t *testing.T) {
+	f := &fakeSessions{active: map[string]string{"s1": "alice"}}
+	useSessions(t, f)
+
+	if active, _ := sessionActive("s1", "alice"); !active {
+		t.Fatal("alice's session is not active")
+	}
+	// A cached entry for one user must not vouch for another
+	if active, _ := sessionActive("s1", "mallory"); active {
+		t.Error("session active for a different user")
+	}
+	if f.calls != 2 {
+		t.Errorf("store checked %d times, want 2", f.calls)
+	}
+}
+
+func TestForgetSession(t *testing.T) {
+	f := &fakeSessions{active: map[string]string{"s1": "alice"}}
+	useSessions(t, f)
+
+	if active, _ := sessionActive("s1", "alice"); !active {
+		t.Fatal("session is not active")
+	}
+	delete(f.active, "s1")
+	ForgetSession("s1")
+	if active, _ := sessionActive("s1", "alice"); active {
+		t.Error("revoked session still active after ForgetSession")
+	}
+}
+
+func TestSessionActiveErrorsAreNotCached(t *testing.T) {
+	f := &fakeSessions{active: map[string]string{"s1": "alice"}, err: errors.New("database down")}
+	useSessions(t, f)
+
+	if _, err := sessionActive("s1", "alice"); err == nil {
+		t.Fatal("sessionActive() hid the store's error")
+	}
+	f.err = nil
+	if active, err := sessionActive("s1", "alice"); err != nil || !active {
+		t.Errorf("sessionActive() = %v, %v after the store recovered, want true", active, err)
+	}
+}
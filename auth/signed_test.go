@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	valid, err := SignToken("reset_password", "token-id", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken() error = %v", err)
+	}
+	expired, err := SignToken("reset_password", "token-id", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("SignToken() error = %v", err)
+	}
+	payload := valid[:strings.LastIndex(valid, ".")]
+	signature := valid[strings.LastIndex(valid, ".")+1:]
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		want    string
+		wantErr error
+	}{
+		{"valid", "reset_password", valid, "token-id", nil},
+		{"other purpose", "verify_email", valid, "", ErrInvalidToken},
+		{"expired", "reset_password", expired, "", ErrTokenExpired},
+		{"longer expiry", "reset_password", "token-id.99999999999." + signature, "", ErrInvalidToken},
+		{"other ID", "reset_password", "other-id" + payload[len("token-id"):] + "." + signature, "", ErrInvalidToken},
+		{"tampered signature", "reset_password", payload + ".AAAA", "", ErrInvalidToken},
+		{"no signature", "reset_password", "token-id", "", ErrInvalidToken},
+		{"empty", "reset_password", "", "", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyToken(tt.purpose, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("VerifyToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenOtherSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	token, err := SignToken("reset_password", "token-id", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken() error = %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "rotated-secret")
+	if _, err := VerifyToken("reset_password", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyToken() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestSignTokenWithoutSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if _, err := SignToken("reset_password", "token-id", time.Now().Add(time.Hour)); err == nil {
+		t.Error("SignToken() succeeded without a secret")
+	}
+	if _, err := VerifyToken("reset_password", "token-id.1.sig"); err == nil {
+		t.Error("VerifyToken() succeeded without a secret")
+	}
+}
@@ -23,7 +23,8 @@ func InitDB() {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable TimeZone=UTC", host, port, user, password, name)
 
 	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError maps constraint violations to gorm.ErrDuplicatedKey etc.
+	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -31,10 +32,42 @@ func InitDB() {
 	log.Println("Connected to database")
 
 	// Run migrations
-	err = DB.AutoMigrate(&models.User{}, &models.ReceiptItem{}, &models.Modifier{}, &models.Receipt{})
+	if err := migrateMoneyColumns(DB); err != nil {
+		log.Fatalf("Failed to migrate money columns: %v", err)
+	}
+
+	err = DB.AutoMigrate(&models.User{}, &models.ReceiptItem{}, &models.Modifier{}, &models.Receipt{}, &models.Participant{}, &models.ItemClaim{}, &models.ExchangeRate{}, &models.ReceiptExchangeRate{}, &models.ShareLink{}, &models.PaymentMethod{}, &models.Settlement{}, &models.ParseJob{}, &models.ParseJobPage{}, &models.ReceiptImage{}, &models.Group{}, &models.GroupMember{}, &models.GroupInvite{}, &models.GroupLedgerEntry{}, &models.GroupPayment{}, &models.RefreshToken{}, &models.Session{}, &models.EmailToken{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	if err := migrateMonzoIDs(DB); err != nil {
+		log.Fatalf("Failed to migrate Monzo IDs: %v", err)
+	}
+
+	if err := migrateSearch(DB); err != nil {
+		log.Fatalf("Failed to set up search: %v", err)
+	}
+
+	if err := migrateUserEmails(DB); err != nil {
+		log.Fatalf("Failed to migrate user emails: %v", err)
+	}
+
+	if err := migrateReceiptIndexes(DB); err != nil {
+		log.Fatalf("Failed to index receipts: %v", err)
+	}
+
+	if err := migrateSessions(DB); err != nil {
+		log.Fatalf("Failed to migrate sessions: %v", err)
+	}
+
+	if err := migrateGroupLedger(DB); err != nil {
+		log.Fatalf("Failed to migrate group ledger: %v", err)
+	}
+
+	if err := migrateGroupPayments(DB); err != nil {
+		log.Fatalf("Failed to migrate group payments: %v", err)
+	}
+
 	log.Println("Database migration completed")
 }
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"fmt"
+	"log"
+
+	"receipt-splitter-backend/money"
+
+	"gorm.io/gorm"
+)
+
+// moneyColumn describes a legacy float column that now holds a money.Money
+type moneyColumn struct {
+	table  string
+	column string
+}
+
+var legacyMoneyColumns = []moneyColumn{
+	{"receipt_items", "price"},
+	{"modifiers", "value"},
+}
+
+// migrateMoneyColumns converts legacy float money columns into integer minor
+// units plus a currency code. Existing rows are assumed to be in the default
+// currency. It runs before AutoMigrate and is a no-op once converted.
+func migrateMoneyColumns(db *gorm.DB) error {
+	for _, c := range legacyMoneyColumns {
+		if !db.Migrator().HasTable(c.table) || !db.Migrator().HasColumn(c.table, c.column) {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			statements := []string{
+				fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s_amount bigint NOT NULL DEFAULT 0`, c.table, c.column),
+				fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s_currency char(3) NOT NULL DEFAULT '%s'`, c.table, c.column, money.DefaultCurrency),
+				// Round via numeric so values like 0.29 don't truncate to 28
+				fmt.Sprintf(`UPDATE %s SET %s_amount = ROUND(%s::numeric * 100)`, c.table, c.column, c.column),
+				fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s`, c.table, c.column),
+			}
+			for _, stmt := range statements {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("converting %s.%s: %w", c.table, c.column, err)
+		}
+
+		log.Printf("Converted %s.%s to minor units", c.table, c.column)
+	}
+	return nil
+}
+
+// migrateMonzoIDs creates a Monzo payment method for each user with a legacy
+// Monzo ID who doesn't have one yet
+func migrateMonzoIDs(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO payment_methods (user_id, type, handle, created_at)
+		SELECT u.id, 'monzo', u.monzo_id, NOW()
+		FROM users u
+		WHERE u.monzo_id <> ''
+		AND NOT EXISTS (
+			SELECT 1 FROM payment_methods pm WHERE pm.user_id = u.id AND pm.type = 'monzo'
+		)`).Error
+}
+
+// migrateSearch sets up full-text search over receipts. Each receipt's
+// search_vector combines its name, its item names and its reason, weighted
+// in that order, and triggers keep it up to date as receipts and items
+// change.
+func migrateSearch(db *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE receipts ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE INDEX IF NOT EXISTS idx_receipts_search_vector ON receipts USING GIN (search_vector)`,
+		`CREATE OR REPLACE FUNCTION receipt_search_vector(rid uuid, rname text, rreason text) RETURNS tsvector AS $$
+			SELECT setweight(to_tsvector('english', coalesce(rname, '')), 'A')
+				|| setweight(to_tsvector('english', coalesce((SELECT string_agg(item, ' ') FROM receipt_items WHERE receipt_id = rid), '')), 'B')
+				|| setweight(to_tsvector('english', coalesce(rreason, '')), 'C')
+		$$ LANGUAGE SQL STABLE`,
+		`CREATE OR REPLACE FUNCTION receipts_search_trigger() RETURNS trigger AS $$
+		BEGIN
+			NEW.search_vector := receipt_search_vector(NEW.id, NEW.name, NEW.reason);
+			RETURN NEW;
+		END
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE FUNCTION receipt_items_search_trigger() RETURNS trigger AS $$
+		DECLARE
+			rid uuid;
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				rid := OLD.receipt_id;
+			ELSE
+				rid := NEW.receipt_id;
+			END IF;
+			UPDATE receipts SET search_vector = receipt_search_vector(id, name, reason) WHERE id = rid;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS receipts_search ON receipts`,
+		`CREATE TRIGGER receipts_search BEFORE INSERT OR UPDATE OF name, reason ON receipts
+			FOR EACH ROW EXECUTE FUNCTION receipts_search_trigger()`,
+		`DROP TRIGGER IF EXISTS receipt_items_search ON receipt_items`,
+		`CREATE TRIGGER receipt_items_search AFTER INSERT OR UPDATE OF item OR DELETE ON receipt_items
+			FOR EACH ROW EXECUTE FUNCTION receipt_items_search_trigger()`,
+		`UPDATE receipts SET search_vector = receipt_search_vector(id, name, reason) WHERE search_vector IS NULL`,
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateReceiptIndexes indexes the expressions the receipt list sorts and
+// filters by, which treat receipts without worked out totals as zero
+func migrateReceiptIndexes(db *gorm.DB) error {
+	statements := []string{
+		`CREATE INDEX IF NOT EXISTS idx_receipts_user_total ON receipts (user_id, (COALESCE(total, 0)), id)`,
+		`CREATE INDEX IF NOT EXISTS idx_receipts_user_outstanding ON receipts (user_id, (COALESCE(outstanding, 0)), id)`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUserEmails lower-cases stored email addresses and makes them unique
+// ignoring case. It fails if two accounts differ only in the case of their
+// email, which has to be sorted out by hand.
+func migrateUserEmails(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email))`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email)`).Error
+	})
+}
+
+// migrateGroupLedger keeps a deleted receipt's ledger entries in its group
+// rather than deleting them with it. AutoMigrate doesn't change existing
+// foreign keys, so the constraint is swapped here.
+func migrateGroupLedger(db *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE group_ledger_entries ALTER COLUMN receipt_id DROP NOT NULL`,
+		`ALTER TABLE group_ledger_entries DROP CONSTRAINT IF EXISTS fk_group_ledger_entries_receipt`,
+		`ALTER TABLE group_ledger_entries ADD CONSTRAINT fk_group_ledger_entries_receipt
+			FOREIGN KEY (receipt_id) REFERENCES receipts(id) ON DELETE SET NULL`,
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateSessions creates sessions for logins from before sessions were
+// tracked, so their refresh tokens keep working
+func migrateSessions(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO sessions (id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at)
+		SELECT family_id, user_id, '', '', MIN(created_at), MAX(created_at), MAX(expires_at)
+		FROM refresh_tokens
+		WHERE revoked_at IS NULL
+		GROUP BY family_id, user_id
+		ON CONFLICT (id) DO NOTHING`).Error
+}
+
+// migrateGroupPayments confirms payments the payee recorded themselves
+// before payments needed confirming
+func migrateGroupPayments(db *gorm.DB) error {
+	return db.Exec(`
+		UPDATE group_payments SET confirmed_at = created_at
+		WHERE confirmed_at IS NULL AND recorded_by_id = to_id`).Error
+}
@@ -0,0 +1,217 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxSize is the largest receipt file accepted, in bytes
+	MaxSize = 10 << 20
+	// MaxTotalSize is the most accepted across all the files of one receipt
+	MaxTotalSize = 30 << 20
+	// MaxPages is the most files, or PDF pages, read for one receipt
+	MaxPages = 10
+	// MaxPixels is the largest image, in pixels, we decode or render. Small
+	// files can hold huge images, which take a lot of memory to decode.
+	MaxPixels = 50_000_000
+	// renderSide is the long side, in pixels, of a PDF page rendered for
+	// OCR: about 340 dpi for A4, and well within MaxPixels for any shape
+	renderSide = 4000
+)
+
+// Content types of the receipt files we accept
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeGIF  = "image/gif"
+	TypeWebP = "image/webp"
+	TypeBMP  = "image/bmp"
+	TypeHEIC = "image/heic"
+	TypePDF  = "application/pdf"
+)
+
+// Supported lists the accepted content types
+var Supported = []string{TypeJPEG, TypePNG, TypeGIF, TypeWebP, TypeBMP, TypeHEIC, TypePDF}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported file format; send a JPEG, PNG, GIF, WebP, BMP, HEIC or PDF")
+	ErrTooLarge          = fmt.Errorf("file is larger than %d MB", MaxSize>>20)
+	ErrTooManyPixels     = fmt.Errorf("image is larger than %d megapixels", MaxPixels/1_000_000)
+)
+
+// heifBrands are the ISO media file brands used by HEIC/HEIF images
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// minTextLength is the fewest non-space characters a PDF's embedded text
+// needs for us to trust it over OCR; scanned PDFs have little or none
+const minTextLength = 20
+
+// Document is a receipt file ready to be read: either images for OCR, one
+// per page, or for PDFs with embedded text, the text itself
+type Document struct {
+	Images [][]byte
+	Text   string
+}
+
+// Sniff works out a file's content type from its first bytes, returning
+// ErrUnsupportedFormat if it isn't one we accept
+func Sniff(data []byte) (string, error) {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && heifBrands[string(data[8:12])] {
+		return TypeHEIC, nil
+	}
+
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	for _, supported := range Supported {
+		if contentType == supported {
+			return contentType, nil
+		}
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Prepare turns an uploaded file into something the OCR provider or receipt
+// structurer can read. PDFs and HEIC images are converted with the poppler
+// (pdftotext, pdftoppm) and libheif (heif-convert) command line tools.
+func Prepare(ctx context.Context, contentType string, data []byte) (*Document, error) {
+	switch contentType {
+	case TypePDF:
+		return preparePDF(ctx, data)
+	case TypeHEIC:
+		image, err := convertHEIC(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		return prepareImage(image)
+	case "":
+		return nil, ErrUnsupportedFormat
+	default:
+		return prepareImage(data)
+	}
+}
+
+// prepareImage checks an image is small enough to decode before handing it
+// to OCR
+func prepareImage(data []byte) (*Document, error) {
+	if err := checkPixels(data); err != nil {
+		return nil, err
+	}
+	return &Document{Images: [][]byte{data}}, nil
+}
+
+// checkPixels reads an image's dimensions from its header, without decoding
+// it, and returns ErrTooManyPixels if it is larger than MaxPixels
+func checkPixels(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return ErrTooManyPixels
+	}
+	return nil
+}
+
+// renderArgs are the pdftoppm options that render a page as a PNG, scaled so
+// its long side is side pixels whatever the page size
+func renderArgs(side int) []string {
+	return []string{"-png", "-scale-to", fmt.Sprint(side)}
+}
+
+// preparePDF uses the PDF's embedded text if it has any, and otherwise
+// renders its pages for OCR
+func preparePDF(ctx context.Context, data []byte) (*Document, error) {
+	text, err := run(ctx, data, "pdftotext", "-layout", "-l", fmt.Sprint(MaxPages), "-", "-")
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.Join(strings.Fields(string(text)), "")) >= minTextLength {
+		return &Document{Text: strings.TrimSpace(string(text))}, nil
+	}
+
+	// pdftoppm only writes one page to stdout, so render into a directory
+	dir, err := os.MkdirTemp("", "receipt-pdf-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	args := append(renderArgs(renderSide), "-l", fmt.Sprint(MaxPages), "-", filepath.Join(dir, "page"))
+	if _, err := run(ctx, data, "pdftoppm", args...); err != nil {
+		return nil, err
+	}
+	// Pages are named page-1.png, page-01.png etc. depending on the page
+	// count, so zero padding keeps them in order
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pages)
+
+	document := &Document{}
+	for _, page := range pages {
+		image, err := os.ReadFile(page)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPixels(image); err != nil {
+			return nil, err
+		}
+		document.Images = append(document.Images, image)
+	}
+	if len(document.Images) == 0 {
+		return nil, errors.New("PDF has no pages")
+	}
+	return document, nil
+}
+
+// convertHEIC converts a HEIC image to JPEG. heif-convert only works on
+// files, so the image goes through a temporary directory.
+func convertHEIC(ctx context.Context, data []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "receipt-heic-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "receipt.heic")
+	out := filepath.Join(dir, "receipt.jpg")
+	if err := os.WriteFile(in, data, 0o600); err != nil {
+		return nil, err
+	}
+	if _, err := run(ctx, nil, "heif-convert", "-q", "90", in, out); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+// run runs a conversion tool with input on stdin and returns its stdout
+func run(ctx context.Context, input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
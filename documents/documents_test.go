@@ -0,0 +1,61 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegSized encodes a tiny JPEG, then rewrites its frame header to claim the
+// given size, the way a small file can hold a huge image
+func jpegSized(t *testing.T, width, height uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	data := buf.Bytes()
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker in encoded JPEG")
+	}
+	// Marker, length, precision, then height and width
+	binary.BigEndian.PutUint16(data[sof+5:], height)
+	binary.BigEndian.PutUint16(data[sof+7:], width)
+	return data
+}
+
+func TestPrepareImagePixels(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint16
+		wantErr       error
+	}{
+		{"small", 1, 1, nil},
+		{"at the limit", 10000, 5000, nil},
+		{"too many pixels", 10000, 5001, ErrTooManyPixels},
+		{"huge", 65535, 65535, ErrTooManyPixels},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := jpegSized(t, tt.width, tt.height)
+			// HEIC uploads reach here too, as heif-convert's JPEG output
+			document, err := prepareImage(data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("prepareImage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(document.Images) != 1 {
+				t.Errorf("got %d images, want 1", len(document.Images))
+			}
+
+			if _, err := Prepare(context.Background(), TypeJPEG, data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Prepare() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
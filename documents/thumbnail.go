@@ -0,0 +1,60 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailSize is the longest side of a thumbnail, in pixels
+const ThumbnailSize = 320
+
+// Thumbnail renders a small JPEG preview of a receipt file. PDFs are
+// previewed by their first page. Images over MaxPixels are skipped with
+// ErrTooManyPixels rather than decoded.
+func Thumbnail(ctx context.Context, contentType string, data []byte) ([]byte, error) {
+	var err error
+	switch contentType {
+	case TypePDF:
+		data, err = run(ctx, data, "pdftoppm", append(renderArgs(ThumbnailSize), "-f", "1", "-l", "1", "-singlefile", "-")...)
+	case TypeHEIC:
+		data, err = convertHEIC(ctx, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPixels(data); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > ThumbnailSize || height > ThumbnailSize {
+		if width >= height {
+			width, height = ThumbnailSize, max(1, height*ThumbnailSize/width)
+		} else {
+			width, height = max(1, width*ThumbnailSize/height), ThumbnailSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
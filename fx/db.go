@@ -0,0 +1,56 @@
+package fx
+
+import (
+	"context"
+	"time"
+
+	"receipt-splitter-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// DBProvider serves rates from the exchange_rates table. Rates are looked up
+// for the currency pair in either direction.
+type DBProvider struct {
+	db *gorm.DB
+}
+
+// NewDBProvider creates a provider backed by the given database
+func NewDBProvider(db *gorm.DB) *DBProvider {
+	return &DBProvider{db: db}
+}
+
+// Rate returns the latest stored rate on or before at, falling back to the
+// earliest stored rate for older dates
+func (p *DBProvider) Rate(ctx context.Context, from, to string, at time.Time) (Rate, error) {
+	if from == to {
+		return Identity(from, at), nil
+	}
+
+	pair := p.db.WithContext(ctx).Where(
+		"(from_currency = ? AND to_currency = ?) OR (from_currency = ? AND to_currency = ?)",
+		from, to, to, from,
+	).Session(&gorm.Session{})
+
+	var row models.ExchangeRate
+	err := pair.Where("as_of <= ?", at).Order("as_of DESC").First(&row).Error
+	if err == gorm.ErrRecordNotFound {
+		err = pair.Order("as_of ASC").First(&row).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return Rate{}, ErrRateNotFound
+	}
+	if err != nil {
+		return Rate{}, err
+	}
+
+	value, err := ParseRate(row.Rate)
+	if err != nil {
+		return Rate{}, err
+	}
+	rate := Rate{From: row.FromCurrency, To: row.ToCurrency, Value: value, AsOf: row.AsOf, Source: "db"}
+	if rate.From != from {
+		rate = rate.Invert()
+	}
+	return rate, nil
+}
@@ -0,0 +1,104 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"receipt-splitter-backend/money"
+)
+
+// FileProvider serves rates from a JSON file so conversion works offline.
+// The file holds one or more snapshots of rates against a base currency:
+//
+//	[{"base": "GBP", "as_of": "2024-12-01", "rates": {"EUR": "1.2051", "USD": "1.2690"}}]
+//
+// Cross rates are derived through the base currency.
+type FileProvider struct {
+	snapshots []snapshot // Sorted oldest first
+}
+
+type snapshot struct {
+	base  string
+	asOf  time.Time
+	rates map[string]*big.Rat
+}
+
+// NewFileProvider loads rates from a JSON file
+func NewFileProvider(path string) (*FileProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []struct {
+		Base  string                 `json:"base"`
+		AsOf  string                 `json:"as_of"`
+		Rates map[string]json.Number `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid rates file: %v", err)
+	}
+
+	p := &FileProvider{}
+	for _, r := range raw {
+		base, err := money.NormaliseCurrency(r.Base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base currency %q", r.Base)
+		}
+		asOf, err := time.Parse("2006-01-02", r.AsOf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid as_of date %q", r.AsOf)
+		}
+
+		s := snapshot{base: base, asOf: asOf, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+		for code, value := range r.Rates {
+			currency, err := money.NormaliseCurrency(code)
+			if err != nil {
+				return nil, fmt.Errorf("invalid currency %q", code)
+			}
+			if s.rates[currency], err = ParseRate(value.String()); err != nil {
+				return nil, err
+			}
+		}
+		p.snapshots = append(p.snapshots, s)
+	}
+
+	sort.Slice(p.snapshots, func(i, j int) bool {
+		return p.snapshots[i].asOf.Before(p.snapshots[j].asOf)
+	})
+	return p, nil
+}
+
+// Rate returns the rate from the latest snapshot on or before at that quotes
+// both currencies, falling back to the earliest snapshot for older dates
+func (p *FileProvider) Rate(ctx context.Context, from, to string, at time.Time) (Rate, error) {
+	if from == to {
+		return Identity(from, at), nil
+	}
+
+	var fallback *snapshot
+	for i := len(p.snapshots) - 1; i >= 0; i-- {
+		s := &p.snapshots[i]
+		if s.rates[from] == nil || s.rates[to] == nil {
+			continue
+		}
+		if !s.asOf.After(at) {
+			return s.rate(from, to), nil
+		}
+		fallback = s
+	}
+	if fallback != nil {
+		return fallback.rate(from, to), nil
+	}
+	return Rate{}, ErrRateNotFound
+}
+
+func (s *snapshot) rate(from, to string) Rate {
+	value := new(big.Rat).Quo(s.rates[to], s.rates[from])
+	return Rate{From: from, To: to, Value: value, AsOf: s.asOf, Source: "file"}
+}
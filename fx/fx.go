@@ -0,0 +1,98 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"receipt-splitter-backend/money"
+
+	"gorm.io/gorm"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// Rate is the price of one unit of From in units of To
+type Rate struct {
+	From   string
+	To     string
+	Value  *big.Rat
+	AsOf   time.Time
+	Source string
+}
+
+// Provider looks up exchange rates
+type Provider interface {
+	// Rate returns the most recent rate from one currency to another that
+	// was published at or before the given time
+	Rate(ctx context.Context, from, to string, at time.Time) (Rate, error)
+}
+
+// Identity returns the rate for converting a currency to itself
+func Identity(currency string, at time.Time) Rate {
+	return Rate{From: currency, To: currency, Value: big.NewRat(1, 1), AsOf: at, Source: "identity"}
+}
+
+// ParseRate parses a decimal exchange rate such as "1.1734"
+func ParseRate(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", s)
+	}
+	return r, nil
+}
+
+// Invert returns the rate in the opposite direction
+func (r Rate) Invert() Rate {
+	return Rate{From: r.To, To: r.From, Value: new(big.Rat).Inv(r.Value), AsOf: r.AsOf, Source: r.Source}
+}
+
+// String formats the rate as a decimal with enough places for display
+func (r Rate) String() string {
+	return r.Value.FloatString(6)
+}
+
+// Convert converts an amount into the rate's target currency, rounding half
+// away from zero to the target's minor unit
+func (r Rate) Convert(m money.Money) (money.Money, error) {
+	if m.Currency != r.From {
+		return money.Money{}, money.ErrCurrencyMismatch
+	}
+
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), r.Value)
+	shift := money.Exponent(r.To) - money.Exponent(r.From)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		v.Mul(v, scale)
+	} else {
+		v.Quo(v, scale)
+	}
+
+	amount, err := money.Round(v)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.New(amount, r.To), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// NewProvider creates the provider named by kind: "file" reads the JSON rate
+// file at path, and "db" (the default) uses the exchange_rates table
+func NewProvider(kind, path string, db *gorm.DB) (Provider, error) {
+	switch kind {
+	case "file":
+		return NewFileProvider(path)
+	case "", "db":
+		return NewDBProvider(db), nil
+	default:
+		return nil, fmt.Errorf("unknown exchange rate provider %q", kind)
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/mail"
+	"receipt-splitter-backend/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+	sendMailTimeout  = 30 * time.Second
+)
+
+var (
+	mailer mail.Mailer
+	// appURL is the frontend that email links open
+	appURL string
+)
+
+// InitMailer sets how account emails are sent and the frontend their links
+// point at
+func InitMailer(m mail.Mailer, frontendURL string) {
+	mailer = m
+	appURL = strings.TrimSuffix(frontendURL, "/")
+}
+
+// errInvalidEmailToken is returned for any link that can't be used, without
+// saying why
+var errInvalidEmailToken = &editError{http.StatusBadRequest, "This link is invalid or has expired"}
+
+// sendEmailToken emails a user a single-use link for a purpose. Earlier
+// links for the same purpose stop working.
+func sendEmailToken(ctx context.Context, user models.User, purpose string, ttl time.Duration, path, subject, body string) error {
+	if mailer == nil {
+		return fmt.Errorf("no mailer configured")
+	}
+
+	now := time.Now()
+	token := models.EmailToken{UserID: user.ID, Purpose: purpose, Email: user.Email, ExpiresAt: now.Add(ttl)}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.EmailToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&token).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	signed, err := auth.SignToken(purpose, token.ID, token.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	link := appURL + path + "?token=" + url.QueryEscape(signed)
+
+	return mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: subject,
+		Text:    fmt.Sprintf("Hi %s,\n\n%s\n\n%s\n\nThis link expires in %s. If you didn't ask for it, you can ignore this email.\n", user.Name, body, link, ttlText(ttl)),
+	})
+}
+
+// ttlText describes a link lifetime in words
+func ttlText(ttl time.Duration) string {
+	if hours := int(ttl.Hours()); hours > 1 {
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return "1 hour"
+}
+
+// sendVerificationEmail emails a user a link to verify their address
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	return sendEmailToken(ctx, user, models.TokenVerifyEmail, verifyEmailTTL, "/verify-email",
+		"Verify your email address", "Please confirm your email address by opening this link:")
+}
+
+// sendVerificationEmailAfter sends the verification email in the background,
+// so sign-up doesn't wait on the mail server
+func sendVerificationEmailAfter(user models.User) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendMailTimeout)
+		defer cancel()
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}()
+}
+
+// useEmailToken checks a token from an emailed link and marks it used. It
+// fails if the link has been used, replaced by a newer one or has expired,
+// or if the user has changed their email address since it was sent.
+func useEmailToken(tx *gorm.DB, purpose, signed string) (*models.EmailToken, *models.User, error) {
+	id, err := auth.VerifyToken(purpose, signed)
+	if err != nil {
+		return nil, nil, errInvalidEmailToken
+	}
+
+	var token models.EmailToken
+	err = tx.Preload("User").Where("id = ? AND purpose = ?", id, purpose).First(&token).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, errInvalidEmailToken
+		}
+		return nil, nil, err
+	}
+	if token.User == nil || !strings.EqualFold(token.User.Email, token.Email) {
+		return nil, nil, errInvalidEmailToken
+	}
+
+	// Marking the token used only succeeds once, even for concurrent requests
+	result := tx.Model(&models.EmailToken{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, errInvalidEmailToken
+	}
+	return &token, token.User, nil
+}
+
+// VerifyEmailHandler marks a user's email address verified using the token
+// from the link they were emailed
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	var user *models.User
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if _, user, err = useEmailToken(tx, models.TokenVerifyEmail, input.Token); err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		return tx.Model(user).Update("email_verified_at", now).Error
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to verify email")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, user)
+}
+
+// ResendVerificationHandler emails the current user a new verification link
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if mailer == nil {
+		helpers.JSONErrorResponse(w, http.StatusServiceUnavailable, "Email is not configured")
+		return
+	}
+
+	var user models.User
+	if err := db.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to query user")
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		helpers.JSONErrorResponse(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	if err := sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		helpers.JSONErrorResponse(w, http.StatusBadGateway, "Failed to send email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ForgotPasswordHandler emails a password reset link to the address given,
+// if it belongs to an account. The response is the same either way, so it
+// can't be used to find out who has an account.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || strings.TrimSpace(input.Email) == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+	email := normaliseEmail(input.Email)
+	if mailer == nil {
+		helpers.JSONErrorResponse(w, http.StatusServiceUnavailable, "Email is not configured")
+		return
+	}
+
+	var user models.User
+	err := db.DB.Where("email = ?", email).First(&user).Error
+	switch {
+	case err == nil:
+		// Send in the background so the response time doesn't give away
+		// whether the account exists
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), sendMailTimeout)
+			defer cancel()
+			err := sendEmailToken(ctx, user, models.TokenResetPassword, resetPasswordTTL, "/reset-password",
+				"Reset your password", "Someone asked to reset the password for your account. To choose a new password, open this link:")
+			if err != nil {
+				log.Printf("Failed to send password reset email to user %s: %v", user.ID, err)
+			}
+		}()
+	case err != gorm.ErrRecordNotFound:
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to query user")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusAccepted, map[string]string{
+		"message": "If an account exists for that email, a reset link has been sent",
+	})
+}
+
+// ResetPasswordHandler sets a new password using the token from a reset
+// link. Every session is logged out, and the email address counts as
+// verified since the user has shown they can read it.
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+	if input.Password == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Password is required")
+		return
+	}
+	if err := validatePassword(input.Password); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Error hashing password")
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		_, user, err := useEmailToken(tx, models.TokenResetPassword, input.Token)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"password": string(hashedPassword)}
+		if user.EmailVerifiedAt == nil {
+			updates["email_verified_at"] = time.Now()
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, "user_id = ?", user.ID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to reset password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,16 +2,43 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"receipt-splitter-backend/auth"
 	"receipt-splitter-backend/db"
 	"receipt-splitter-backend/helpers"
 	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/payments"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+const (
+	minPasswordLength = 8
+	// bcrypt only uses the first 72 bytes of a password
+	maxPasswordBytes = 72
+)
+
+// normaliseEmail puts an email address in the form it is stored in, so
+// addresses differing only in case belong to the same account
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validatePassword checks a new password is long enough, and short enough
+// to hash
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordBytes)
+	}
+	return nil
+}
+
 // RegisterInput represents the input for the RegisterHandler
 type RegisterInput struct {
 	Name     string `json:"name"`
@@ -31,10 +58,15 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate input fields
+	input.Email = normaliseEmail(input.Email)
 	if input.Name == "" || input.Email == "" || input.Password == "" {
 		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Name, email, and password are required")
 		return
 	}
+	if err := validatePassword(input.Password); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
@@ -51,10 +83,20 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		MonzoID:  input.MonzoID,
 	}
 
+	// Keep the legacy Monzo ID as the user's first payment method
+	if input.MonzoID != "" {
+		monzoID, err := payments.NormaliseMonzoID(input.MonzoID)
+		if err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid Monzo ID")
+			return
+		}
+		user.PaymentMethods = []models.PaymentMethod{{Type: payments.TypeMonzo, Handle: monzoID}}
+	}
+
 	// Insert the user into the database using GORM
 	err = db.DB.Create(&user).Error
 	if err != nil {
-		if gorm.ErrDuplicatedKey == err {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			helpers.JSONErrorResponse(w, http.StatusConflict, "Email already exists")
 			return
 		}
@@ -62,6 +104,11 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Ask the user to confirm their email address
+	if mailer != nil {
+		sendVerificationEmailAfter(user)
+	}
+
 	// Omit the password from the response
 	user.Password = ""
 
@@ -83,6 +130,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate input
+	credentials.Email = normaliseEmail(credentials.Email)
 	if credentials.Email == "" || credentials.Password == "" {
 		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Email and password are required")
 		return
@@ -106,8 +154,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT
-	token, err := auth.GenerateJWT(user.ID)
+	// Generate an access token and a refresh token
+	response, err := newSession(r, user.ID)
 	if err != nil {
 		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to generate token")
 		return
@@ -116,9 +164,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// Omit password from the response
 	user.Password = ""
 
-	// Respond with user info and token
-	helpers.JSONResponse(w, http.StatusOK, map[string]interface{}{
-		"user":  user,
-		"token": token,
-	})
+	// Respond with user info and tokens
+	response["user"] = user
+	helpers.JSONResponse(w, http.StatusOK, response)
 }
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/fx"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/split"
+
+	"gorm.io/gorm/clause"
+)
+
+var exchangeRates fx.Provider
+
+// InitExchangeRates sets the provider used to convert receipts between currencies
+func InitExchangeRates(provider fx.Provider) {
+	exchangeRates = provider
+}
+
+// settlement is a share converted into the currency a participant pays in
+type settlement struct {
+	Amount money.Money
+	Rate   fx.Rate
+}
+
+// receiptRate returns the rate for converting a receipt into another
+// currency. A rate recorded on the receipt is reused, so a participant's
+// share doesn't drift as rates change. Otherwise the live rate is returned,
+// and recorded only if record is set: rates are fixed when the payer asks
+// for them or money is requested, not whenever anyone views the receipt.
+func receiptRate(ctx context.Context, receipt *models.Receipt, to string, record bool) (fx.Rate, error) {
+	if to == receipt.Currency {
+		return fx.Identity(to, receipt.CreatedAt), nil
+	}
+
+	for _, recorded := range receipt.ExchangeRates {
+		if recorded.FromCurrency == receipt.Currency && recorded.ToCurrency == to {
+			value, err := fx.ParseRate(recorded.Rate)
+			if err != nil {
+				return fx.Rate{}, err
+			}
+			return fx.Rate{From: receipt.Currency, To: to, Value: value, AsOf: recorded.AsOf, Source: recorded.Source}, nil
+		}
+	}
+
+	if exchangeRates == nil {
+		return fx.Rate{}, errors.New("exchange rates not configured")
+	}
+	rate, err := exchangeRates.Rate(ctx, receipt.Currency, to, receipt.CreatedAt)
+	if err != nil {
+		return fx.Rate{}, err
+	}
+	if !record {
+		return rate, nil
+	}
+
+	recorded := models.ReceiptExchangeRate{
+		ReceiptID:    receipt.ID,
+		FromCurrency: rate.From,
+		ToCurrency:   rate.To,
+		Rate:         rate.Value.FloatString(10),
+		AsOf:         rate.AsOf,
+		Source:       rate.Source,
+	}
+	// A concurrent request may have recorded a rate first; keep theirs
+	err = db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&recorded).Error
+	if err != nil {
+		return fx.Rate{}, err
+	}
+	var stored models.ReceiptExchangeRate
+	err = db.DB.Where("receipt_id = ? AND from_currency = ? AND to_currency = ?", receipt.ID, rate.From, rate.To).First(&stored).Error
+	if err != nil {
+		return fx.Rate{}, err
+	}
+	receipt.ExchangeRates = append(receipt.ExchangeRates, stored)
+
+	value, err := fx.ParseRate(stored.Rate)
+	if err != nil {
+		return fx.Rate{}, err
+	}
+	return fx.Rate{From: stored.FromCurrency, To: stored.ToCurrency, Value: value, AsOf: stored.AsOf, Source: stored.Source}, nil
+}
+
+// settleShares converts each share into the currency its participant pays
+// in. If currency is set, every share is converted into it instead. Shares
+// already in the receipt's currency are left out. Rates are recorded on the
+// receipt only if record is set.
+func settleShares(ctx context.Context, receipt *models.Receipt, result *split.Result, currency string, record bool) (map[string]settlement, error) {
+	currencies := map[string]string{}
+	for _, p := range receipt.Participants {
+		currencies[p.ID] = p.SettlementCurrency
+	}
+
+	settlements := map[string]settlement{}
+	for _, share := range result.Shares {
+		to := currencies[share.Person]
+		if currency != "" {
+			to = currency
+		}
+		if to == "" || to == result.Currency {
+			continue
+		}
+
+		rate, err := receiptRate(ctx, receipt, to, record)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := rate.Convert(money.New(share.Total, result.Currency))
+		if err != nil {
+			return nil, err
+		}
+		settlements[share.Person] = settlement{Amount: amount, Rate: rate}
+	}
+	return settlements, nil
+}
+
+// convertForReceipt converts an amount in the receipt's currency into another
+// currency using the rate recorded on the receipt, recording one if needed
+func convertForReceipt(ctx context.Context, receipt *models.Receipt, amount money.Money, to string) (money.Money, error) {
+	if amount.Currency == to {
+		return amount, nil
+	}
+	rate, err := receiptRate(ctx, receipt, to, true)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return rate.Convert(amount)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InviteGroupMemberHandler invites someone to a group by email address. Any
+// member can invite people. The response is the same whether or not the
+// address belongs to an account, so it can't be used to find out who has
+// one.
+func InviteGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	groupID := groupFromContext(r.Context()).GroupID
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	if email == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	// Inviting the same address twice keeps the first invite
+	invite := models.GroupInvite{GroupID: groupID, Email: email, InvitedByID: userID}
+	if err := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&invite).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to invite member")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusAccepted, map[string]string{
+		"message": "If that email belongs to an account, they can now accept the invite",
+	})
+}
+
+// GetGroupInvitesHandler lists a group's pending invites
+func GetGroupInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := groupFromContext(r.Context()).GroupID
+
+	var invites []models.GroupInvite
+	if err := db.DB.Where("group_id = ?", groupID).Order("created_at").Find(&invites).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch invites")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, invites)
+}
+
+// CancelGroupInviteHandler withdraws a pending invite. Whoever sent it and
+// the group owner can cancel it.
+func CancelGroupInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	access := groupFromContext(r.Context())
+
+	inviteID := mux.Vars(r)["inviteId"]
+	if _, err := uuid.Parse(inviteID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	query := db.DB.Where("id = ? AND group_id = ?", inviteID, access.GroupID)
+	if !access.Owner {
+		query = query.Where("invited_by_id = ?", userID)
+	}
+	result := query.Delete(&models.GroupInvite{})
+	if result.Error != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to cancel invite")
+		return
+	}
+	if result.RowsAffected == 0 {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findUserInvite loads an invite sent to the current user's email address.
+// Only verified addresses count, so nobody can pick up invites by signing
+// up with someone else's email.
+func findUserInvite(tx *gorm.DB, userID, inviteID string) (*models.GroupInvite, error) {
+	var user models.User
+	if err := tx.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.EmailVerifiedAt == nil {
+		return nil, &editError{http.StatusForbidden, "Verify your email address to accept invites"}
+	}
+	if _, err := uuid.Parse(inviteID); err != nil {
+		return nil, &editError{http.StatusNotFound, "Invite not found"}
+	}
+
+	var invite models.GroupInvite
+	err := tx.Preload("Group").Where("id = ? AND email = ?", inviteID, strings.ToLower(user.Email)).First(&invite).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &editError{http.StatusNotFound, "Invite not found"}
+		}
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// GetMyGroupInvitesHandler lists the groups the current user has been
+// invited to
+func GetMyGroupInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var user models.User
+	if err := db.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to query user")
+		return
+	}
+	formattedInvites := []map[string]interface{}{}
+	if user.EmailVerifiedAt == nil {
+		helpers.JSONResponse(w, http.StatusOK, formattedInvites)
+		return
+	}
+
+	var invites []models.GroupInvite
+	err := db.DB.Preload("Group").Where("email = ?", strings.ToLower(user.Email)).Order("created_at").Find(&invites).Error
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch invites")
+		return
+	}
+
+	for _, invite := range invites {
+		name := ""
+		if invite.Group != nil {
+			name = invite.Group.Name
+		}
+		formattedInvites = append(formattedInvites, map[string]interface{}{
+			"id":         invite.ID,
+			"group_id":   invite.GroupID,
+			"group_name": name,
+			"invited_by": invite.InvitedByID,
+			"created_at": invite.CreatedAt,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, formattedInvites)
+}
+
+// AcceptGroupInviteHandler makes the current user a member of the group they
+// were invited to
+func AcceptGroupInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var groupID string
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		invite, err := findUserInvite(tx, userID, mux.Vars(r)["inviteId"])
+		if err != nil {
+			return err
+		}
+		groupID = invite.GroupID
+
+		if err := tx.Delete(invite).Error; err != nil {
+			return err
+		}
+		// Accepting after joining some other way just uses up the invite
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.GroupMember{GroupID: groupID, UserID: userID}).Error
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to accept invite")
+		return
+	}
+
+	// Receipts they already joined now count towards the group's balances
+	refreshGroupReceipts(groupID)
+
+	data, err := groupData(groupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve group")
+		return
+	}
+	helpers.JSONResponse(w, http.StatusOK, data)
+}
+
+// DeclineGroupInviteHandler turns down an invite sent to the current user
+func DeclineGroupInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		invite, err := findUserInvite(tx, userID, mux.Vars(r)["inviteId"])
+		if err != nil {
+			return err
+		}
+		return tx.Delete(invite).Error
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to decline invite")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// RecordGroupPaymentHandler records one member paying another back, e.g. a
+// transfer from the settlement plan. Either the payer or the payee can
+// record it, but it only counts towards the group's balances once the payee
+// has confirmed it. A payment recorded by the payee is confirmed straight
+// away.
+func RecordGroupPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	groupID := groupFromContext(r.Context()).GroupID
+
+	var input struct {
+		From   string      `json:"from"`
+		To     string      `json:"to"`
+		Amount money.Money `json:"amount"`
+		Method string      `json:"method"`
+		Note   string      `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if input.From == "" {
+		input.From = userID
+	}
+	if input.From == input.To {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "A payment must be between two different members")
+		return
+	}
+	if input.From != userID && input.To != userID {
+		helpers.JSONErrorResponse(w, http.StatusForbidden, "Only the payer or the payee can record a payment")
+		return
+	}
+	amount, err := input.Amount.Resolve("")
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Amount needs a known currency")
+		return
+	}
+	if amount.Amount <= 0 {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Amount must be positive")
+		return
+	}
+
+	payment := models.GroupPayment{
+		GroupID:      groupID,
+		FromID:       input.From,
+		ToID:         input.To,
+		Amount:       amount,
+		Method:       strings.TrimSpace(input.Method),
+		Note:         strings.TrimSpace(input.Note),
+		RecordedByID: userID,
+	}
+	if payment.ToID == userID {
+		now := time.Now()
+		payment.ConfirmedAt = &now
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, memberID := range []string{payment.FromID, payment.ToID} {
+			if _, err := uuid.Parse(memberID); err != nil {
+				return &editError{http.StatusBadRequest, "Both people must be members of the group"}
+			}
+			member, err := isGroupMember(tx, groupID, memberID)
+			if err != nil {
+				return err
+			}
+			if !member {
+				return &editError{http.StatusBadRequest, "Both people must be members of the group"}
+			}
+		}
+		return tx.Create(&payment).Error
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to record payment")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusCreated, payment)
+}
+
+// GetGroupPaymentsHandler lists the payments recorded in a group, newest
+// first
+func GetGroupPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := groupFromContext(r.Context()).GroupID
+
+	var payments []models.GroupPayment
+	if err := db.DB.Where("group_id = ?", groupID).Order("created_at DESC").Find(&payments).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch payments")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, payments)
+}
+
+// findGroupPayment loads a payment in a group, writing an error response if
+// it can't
+func findGroupPayment(w http.ResponseWriter, groupID, paymentID string) (*models.GroupPayment, bool) {
+	if _, err := uuid.Parse(paymentID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Payment not found")
+		return nil, false
+	}
+
+	var payment models.GroupPayment
+	if err := db.DB.Where("id = ? AND group_id = ?", paymentID, groupID).First(&payment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Payment not found")
+			return nil, false
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve payment")
+		return nil, false
+	}
+	return &payment, true
+}
+
+// ConfirmGroupPaymentHandler lets the payee confirm they received a payment,
+// after which it counts towards the group's balances
+func ConfirmGroupPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	groupID := groupFromContext(r.Context()).GroupID
+
+	payment, ok := findGroupPayment(w, groupID, mux.Vars(r)["paymentId"])
+	if !ok {
+		return
+	}
+	if payment.ToID != userID {
+		helpers.JSONErrorResponse(w, http.StatusForbidden, "Only the payee can confirm a payment")
+		return
+	}
+
+	if payment.ConfirmedAt == nil {
+		now := time.Now()
+		result := db.DB.Model(&models.GroupPayment{}).
+			Where("id = ? AND confirmed_at IS NULL", payment.ID).
+			Update("confirmed_at", now)
+		if result.Error != nil {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to confirm payment")
+			return
+		}
+		payment.ConfirmedAt = &now
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, payment)
+}
+
+// DeleteGroupPaymentHandler removes a payment recorded by mistake. The
+// payer or the payee can remove a pending payment, but once confirmed only
+// the payee can, since removing it puts the debt back.
+func DeleteGroupPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	groupID := groupFromContext(r.Context()).GroupID
+
+	payment, ok := findGroupPayment(w, groupID, mux.Vars(r)["paymentId"])
+	if !ok {
+		return
+	}
+	if payment.FromID != userID && payment.ToID != userID {
+		helpers.JSONErrorResponse(w, http.StatusForbidden, "Only the payer or the payee can remove a payment")
+		return
+	}
+
+	query := db.DB.Where("id = ?", payment.ID)
+	if payment.ToID != userID {
+		// The payee may confirm it meanwhile
+		query = query.Where("confirmed_at IS NULL")
+	}
+	result := query.Delete(&models.GroupPayment{})
+	if result.Error != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to delete payment")
+		return
+	}
+	if result.RowsAffected == 0 {
+		helpers.JSONErrorResponse(w, http.StatusForbidden, "Only the payee can remove a confirmed payment")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,596 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/payments"
+	"receipt-splitter-backend/split"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// groupAccessKey is the context key for the group a request may act on
+type groupAccessKey struct{}
+
+// groupAccess records the group in the {id} path variable and whether the
+// user making the request owns it
+type groupAccess struct {
+	GroupID string
+	Owner   bool
+}
+
+// groupFromContext returns the group access granted by GroupMemberMiddleware
+func groupFromContext(ctx context.Context) groupAccess {
+	access, _ := ctx.Value(groupAccessKey{}).(groupAccess)
+	return access
+}
+
+// isGroupMember reports whether a user belongs to a group
+func isGroupMember(tx *gorm.DB, groupID, userID string) (bool, error) {
+	var count int64
+	err := tx.Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+// GroupMemberMiddleware only lets members of the group in the {id} path
+// variable through. It must run after auth.JWTMiddleware.
+func GroupMemberMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserIDFromContext(r.Context())
+		if !ok {
+			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if _, err := uuid.Parse(id); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Group not found")
+			return
+		}
+
+		var group models.Group
+		err := db.DB.Where("id = ? AND EXISTS (SELECT 1 FROM group_members m WHERE m.group_id = groups.id AND m.user_id = ?)", id, userID).
+			First(&group).Error
+		if err != nil {
+			// Don't reveal whether other people's groups exist
+			if err == gorm.ErrRecordNotFound {
+				helpers.JSONErrorResponse(w, http.StatusNotFound, "Group not found")
+				return
+			}
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve group")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), groupAccessKey{}, groupAccess{GroupID: group.ID, Owner: group.OwnerID == userID})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// checkReceiptGroup makes sure a receipt's owner may put it in a group
+func checkReceiptGroup(groupID, userID string) error {
+	if _, err := uuid.Parse(groupID); err != nil {
+		return &editError{http.StatusBadRequest, "Group not found"}
+	}
+	member, err := isGroupMember(db.DB, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return &editError{http.StatusBadRequest, "Group not found"}
+	}
+	return nil
+}
+
+// refreshGroupLedger rewrites the ledger entries of a receipt from its
+// participants' balances. Only participants who are members of the
+// receipt's group, linked to their account, count towards group balances;
+// guests settle up on the receipt itself.
+func refreshGroupLedger(tx *gorm.DB, receipt *models.Receipt, balances []participantBalance) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		var existing []models.GroupLedgerEntry
+		if err := tx.Where("receipt_id = ?", receipt.ID).Find(&existing).Error; err != nil {
+			return err
+		}
+
+		isMember := map[string]bool{}
+		if receipt.GroupID != nil {
+			var members []string
+			if err := tx.Model(&models.GroupMember{}).Where("group_id = ?", *receipt.GroupID).Pluck("user_id", &members).Error; err != nil {
+				return err
+			}
+			for _, id := range members {
+				isMember[id] = true
+			}
+		}
+
+		if stale := staleLedgerEntries(receipt, existing, isMember); len(stale) > 0 {
+			if err := tx.Where("id IN ?", stale).Delete(&models.GroupLedgerEntry{}).Error; err != nil {
+				return err
+			}
+		}
+		entries := groupLedgerEntries(receipt, balances, isMember)
+		if len(entries) == 0 {
+			return nil
+		}
+		return tx.Create(&entries).Error
+	})
+}
+
+// staleLedgerEntries picks out the IDs of a receipt's ledger entries that
+// are due to be rewritten: those between current members of its group, and
+// any left from a group it is no longer in. Entries with a former member are
+// kept as they were, since the member settled up against them before
+// leaving and their payments still count.
+func staleLedgerEntries(receipt *models.Receipt, existing []models.GroupLedgerEntry, isMember map[string]bool) []string {
+	var stale []string
+	for _, e := range existing {
+		if receipt.GroupID == nil || e.GroupID != *receipt.GroupID || (isMember[e.DebtorID] && isMember[e.CreditorID]) {
+			stale = append(stale, e.ID)
+		}
+	}
+	return stale
+}
+
+// groupLedgerEntries works out what each group member owes the payer of a
+// group receipt, and how much of it has been marked paid on the receipt.
+// Forgiven shares are left out.
+func groupLedgerEntries(receipt *models.Receipt, balances []participantBalance, isMember map[string]bool) []models.GroupLedgerEntry {
+	if receipt.GroupID == nil || !isMember[receipt.UserID] {
+		return nil
+	}
+
+	var entries []models.GroupLedgerEntry
+	for _, b := range balances {
+		p := b.Participant
+		if p.UserID == nil || !isMember[*p.UserID] || b.Owed.Amount == 0 {
+			continue
+		}
+		if b.Settlement != nil && b.Settlement.Status == models.SettlementForgiven {
+			continue
+		}
+		entries = append(entries, models.GroupLedgerEntry{
+			GroupID:    *receipt.GroupID,
+			ReceiptID:  &receipt.ID,
+			DebtorID:   *p.UserID,
+			CreditorID: receipt.UserID,
+			Amount:     b.Owed,
+			Settled:    b.Owed.Amount - b.Outstanding.Amount,
+		})
+	}
+	return entries
+}
+
+// refreshGroupReceipts refreshes every receipt in a group, e.g. after its
+// members change
+func refreshGroupReceipts(groupID string) {
+	var ids []string
+	if err := db.DB.Model(&models.Receipt{}).Where("group_id = ?", groupID).Pluck("id", &ids).Error; err != nil {
+		return
+	}
+	for _, id := range ids {
+		refreshTotalsAfter(id)
+	}
+}
+
+// groupData is a group with its members' names
+func groupData(groupID string) (map[string]interface{}, error) {
+	var group models.Group
+	if err := db.DB.Preload("Members.User").First(&group, "id = ?", groupID).Error; err != nil {
+		return nil, err
+	}
+
+	members := make([]map[string]interface{}, 0, len(group.Members))
+	for _, m := range group.Members {
+		name := ""
+		if m.User != nil {
+			name = m.User.Name
+		}
+		members = append(members, map[string]interface{}{
+			"user_id":   m.UserID,
+			"name":      name,
+			"owner":     m.UserID == group.OwnerID,
+			"joined_at": m.CreatedAt,
+		})
+	}
+
+	return map[string]interface{}{
+		"id":         group.ID,
+		"name":       group.Name,
+		"owner_id":   group.OwnerID,
+		"members":    members,
+		"created_at": group.CreatedAt,
+	}, nil
+}
+
+// CreateGroupHandler creates a group with the current user as its owner and
+// first member
+func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	group := models.Group{
+		Name:    input.Name,
+		OwnerID: userID,
+		Members: []models.GroupMember{{UserID: userID}},
+	}
+	if err := db.DB.Create(&group).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to create group")
+		return
+	}
+
+	data, err := groupData(group.ID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve group")
+		return
+	}
+	helpers.JSONResponse(w, http.StatusCreated, data)
+}
+
+// GetGroupsHandler lists the groups the current user belongs to
+func GetGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var groups []models.Group
+	err := db.DB.Where("id IN (SELECT group_id FROM group_members WHERE user_id = ?)", userID).
+		Order("name").Find(&groups).Error
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch groups")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, groups)
+}
+
+// GetGroupHandler returns a group with its members
+func GetGroupHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := groupData(groupFromContext(r.Context()).GroupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve group")
+		return
+	}
+	helpers.JSONResponse(w, http.StatusOK, data)
+}
+
+// RemoveGroupMemberHandler removes a member from a group. Members can leave
+// and the owner can remove anyone else, but only once they are settled up.
+func RemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	access := groupFromContext(r.Context())
+	memberID := mux.Vars(r)["userId"]
+
+	if memberID != userID && !access.Owner {
+		helpers.JSONErrorResponse(w, http.StatusForbidden, "Only the group owner can remove other members")
+		return
+	}
+	if memberID == userID && access.Owner {
+		helpers.JSONErrorResponse(w, http.StatusConflict, "The group owner can't leave the group")
+		return
+	}
+	if _, err := uuid.Parse(memberID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Member not found")
+		return
+	}
+
+	debts, err := groupDebts(access.GroupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+	owing := false
+	for _, d := range debts {
+		owing = owing || d.From == memberID || d.To == memberID
+	}
+	if owing {
+		helpers.JSONErrorResponse(w, http.StatusConflict, "Member has outstanding balances in the group")
+		return
+	}
+
+	result := db.DB.Where("group_id = ? AND user_id = ?", access.GroupID, memberID).Delete(&models.GroupMember{})
+	if result.Error != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+	if result.RowsAffected == 0 {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Member not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// groupDebt is what one member owes another across a group's receipts
+type groupDebt struct {
+	From   string
+	To     string
+	Amount money.Money
+}
+
+// groupDebts nets a group's ledger and the confirmed payments between its
+// members into at most one debt per pair of members and currency. Currencies
+// are kept apart rather than converted, since members pay each other back in
+// the currency they were charged in.
+func groupDebts(groupID string) ([]groupDebt, error) {
+	var entries []models.GroupLedgerEntry
+	if err := db.DB.Where("group_id = ?", groupID).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	var paid []models.GroupPayment
+	if err := db.DB.Where("group_id = ? AND confirmed_at IS NOT NULL", groupID).Find(&paid).Error; err != nil {
+		return nil, err
+	}
+	return netGroupDebts(entries, paid), nil
+}
+
+// netGroupDebts nets ledger entries and payments into at most one debt per
+// pair of members and currency, largest first. Pending payments are
+// skipped.
+//
+// Members can pay a share back through a group payment or by having it
+// marked paid on the receipt. A share marked paid only counts for what group
+// payments between the two haven't already covered, so a debt settled both
+// ways isn't counted twice.
+func netGroupDebts(entries []models.GroupLedgerEntry, paid []models.GroupPayment) []groupDebt {
+	// Totals from one member to another, keyed by direction
+	type direction struct{ from, to, currency string }
+	owed := map[direction]int64{}
+	settled := map[direction]int64{}
+	payments := map[direction]int64{}
+	for _, e := range entries {
+		d := direction{e.DebtorID, e.CreditorID, e.Amount.Currency}
+		owed[d] += e.Amount.Amount
+		settled[d] += e.Settled
+	}
+	for _, p := range paid {
+		if p.ConfirmedAt == nil {
+			continue
+		}
+		payments[direction{p.FromID, p.ToID, p.Amount.Currency}] += p.Amount.Amount
+	}
+
+	// Keyed by the pair in a fixed order, positive when the first owes the second
+	type pair struct{ a, b, currency string }
+	net := map[pair]int64{}
+	add := func(d direction, amount int64) {
+		if d.from < d.to {
+			net[pair{d.from, d.to, d.currency}] += amount
+		} else {
+			net[pair{d.to, d.from, d.currency}] -= amount
+		}
+	}
+	for d, amount := range owed {
+		add(d, amount-min(settled[d], max(amount-payments[d], 0)))
+	}
+	// A payment leaves the payee owing the payer what was paid
+	for d, amount := range payments {
+		add(d, -amount)
+	}
+
+	debts := []groupDebt{}
+	for p, amount := range net {
+		switch {
+		case amount > 0:
+			debts = append(debts, groupDebt{From: p.a, To: p.b, Amount: money.New(amount, p.currency)})
+		case amount < 0:
+			debts = append(debts, groupDebt{From: p.b, To: p.a, Amount: money.New(-amount, p.currency)})
+		}
+	}
+	sort.Slice(debts, func(i, j int) bool {
+		if debts[i].Amount.Currency != debts[j].Amount.Currency {
+			return debts[i].Amount.Currency < debts[j].Amount.Currency
+		}
+		if debts[i].Amount.Amount != debts[j].Amount.Amount {
+			return debts[i].Amount.Amount > debts[j].Amount.Amount
+		}
+		return debts[i].From+debts[i].To < debts[j].From+debts[j].To
+	})
+	return debts
+}
+
+// netBalances works out what each member is owed (positive) or owes
+// (negative) overall, by currency then user ID
+func netBalances(debts []groupDebt) map[string]map[string]int64 {
+	net := map[string]map[string]int64{}
+	for _, d := range debts {
+		currency := d.Amount.Currency
+		if net[currency] == nil {
+			net[currency] = map[string]int64{}
+		}
+		net[currency][d.From] -= d.Amount.Amount
+		net[currency][d.To] += d.Amount.Amount
+	}
+	return net
+}
+
+// sortedKeys returns the currencies of a set of balances in order
+func sortedKeys(net map[string]map[string]int64) []string {
+	keys := make([]string, 0, len(net))
+	for k := range net {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// memberNames maps a group's members' user IDs to their names
+func memberNames(groupID string) (map[string]string, []string, error) {
+	var members []models.GroupMember
+	if err := db.DB.Preload("User").Where("group_id = ?", groupID).Order("created_at").Find(&members).Error; err != nil {
+		return nil, nil, err
+	}
+	names := map[string]string{}
+	order := make([]string, 0, len(members))
+	for _, m := range members {
+		if m.User != nil {
+			names[m.UserID] = m.User.Name
+		}
+		order = append(order, m.UserID)
+	}
+	return names, order, nil
+}
+
+// GetGroupBalancesHandler shows who owes whom across all of a group's
+// receipts. Each member's balance per currency is positive when they are
+// owed money and negative when they owe it; debts are netted between each
+// pair of members.
+func GetGroupBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := groupFromContext(r.Context()).GroupID
+
+	debts, err := groupDebts(groupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch balances")
+		return
+	}
+	names, order, err := memberNames(groupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch balances")
+		return
+	}
+
+	formattedDebts := make([]map[string]interface{}, 0, len(debts))
+	for _, d := range debts {
+		formattedDebts = append(formattedDebts, map[string]interface{}{
+			"from":      d.From,
+			"from_name": names[d.From],
+			"to":        d.To,
+			"to_name":   names[d.To],
+			"amount":    d.Amount,
+		})
+	}
+
+	net := netBalances(debts)
+	members := make([]map[string]interface{}, 0, len(order))
+	for _, userID := range order {
+		balances := []money.Money{}
+		for _, currency := range sortedKeys(net) {
+			if amount, ok := net[currency][userID]; ok {
+				balances = append(balances, money.New(amount, currency))
+			}
+		}
+		members = append(members, map[string]interface{}{
+			"user_id":  userID,
+			"name":     names[userID],
+			"balances": balances,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"group_id": groupID,
+		"members":  members,
+		"debts":    formattedDebts,
+	})
+}
+
+// GetGroupSettlementPlanHandler plans the fewest transfers that clear every
+// balance in a group, separately for each currency, and offers payment links
+// for each transfer through the methods the recipient accepts in that
+// currency
+func GetGroupSettlementPlanHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := groupFromContext(r.Context()).GroupID
+
+	var group models.Group
+	if err := db.DB.First(&group, "id = ?", groupID).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve group")
+		return
+	}
+	debts, err := groupDebts(groupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch balances")
+		return
+	}
+	names, _, err := memberNames(groupID)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch balances")
+		return
+	}
+
+	type plannedTransfer struct {
+		split.Transfer
+		Currency string
+	}
+	var planned []plannedTransfer
+	payees := []string{}
+	net := netBalances(debts)
+	for _, currency := range sortedKeys(net) {
+		for _, t := range split.Settle(net[currency]) {
+			planned = append(planned, plannedTransfer{t, currency})
+			payees = append(payees, t.To)
+		}
+	}
+
+	methods := map[string][]models.PaymentMethod{}
+	if len(payees) > 0 {
+		var all []models.PaymentMethod
+		if err := db.DB.Where("user_id IN ?", payees).Order("created_at").Find(&all).Error; err != nil {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch payment methods")
+			return
+		}
+		for _, m := range all {
+			methods[m.UserID] = append(methods[m.UserID], m)
+		}
+	}
+
+	reference := payments.GroupReference(group)
+	transfers := make([]map[string]interface{}, 0, len(planned))
+	for _, t := range planned {
+		amount := money.New(t.Amount, t.Currency)
+		options := []payments.Link{}
+		for _, method := range methods[t.To] {
+			provider, err := payments.Get(method.Type)
+			if err != nil || !payments.Accepts(provider, t.Currency) {
+				continue
+			}
+			link, err := provider.Link(method, amount, reference)
+			if err != nil {
+				continue
+			}
+			options = append(options, link)
+		}
+
+		transfers = append(transfers, map[string]interface{}{
+			"from":      t.From,
+			"from_name": names[t.From],
+			"to":        t.To,
+			"to_name":   names[t.To],
+			"amount":    amount,
+			"options":   options,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"group_id":  groupID,
+		"reference": reference,
+		"debts":     len(debts),
+		"transfers": transfers,
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+func TestGroupBalancesAfterPayment(t *testing.T) {
+	const (
+		alice = "alice"
+		bob   = "bob"
+	)
+	groupID := "group"
+	isMember := map[string]bool{alice: true, bob: true}
+	confirmed := time.Now()
+	payment := models.GroupPayment{FromID: bob, ToID: alice, Amount: money.New(1000, "GBP"), ConfirmedAt: &confirmed}
+
+	tests := []struct {
+		name     string
+		status   string
+		payments []models.GroupPayment
+		want     []groupDebt
+	}{
+		{
+			name: "unpaid",
+			want: []groupDebt{{From: bob, To: alice, Amount: money.New(1000, "GBP")}},
+		},
+		{
+			name:     "paid through the group",
+			payments: []models.GroupPayment{payment},
+			want:     []groupDebt{},
+		},
+		{
+			name:     "paid through the group, then marked paid on the receipt",
+			status:   models.SettlementPaid,
+			payments: []models.GroupPayment{payment},
+			want:     []groupDebt{},
+		},
+		{
+			name:   "marked paid on the receipt only",
+			status: models.SettlementPaid,
+			want:   []groupDebt{},
+		},
+		{
+			name:     "paid partly through the group, then marked paid on the receipt",
+			status:   models.SettlementPaid,
+			payments: []models.GroupPayment{{FromID: bob, ToID: alice, Amount: money.New(400, "GBP"), ConfirmedAt: &confirmed}},
+			want:     []groupDebt{},
+		},
+		{
+			name:     "overpaid through the group, then marked paid on the receipt",
+			status:   models.SettlementPaid,
+			payments: []models.GroupPayment{{FromID: bob, ToID: alice, Amount: money.New(1500, "GBP"), ConfirmedAt: &confirmed}},
+			want:     []groupDebt{{From: alice, To: bob, Amount: money.New(500, "GBP")}},
+		},
+		{
+			name:   "forgiven",
+			status: models.SettlementForgiven,
+			want:   []groupDebt{},
+		},
+		{
+			name:     "payment still pending",
+			payments: []models.GroupPayment{{FromID: bob, ToID: alice, Amount: money.New(1000, "GBP")}},
+			want:     []groupDebt{{From: bob, To: alice, Amount: money.New(1000, "GBP")}},
+		},
+		{
+			name:     "overpaid",
+			payments: []models.GroupPayment{{FromID: bob, ToID: alice, Amount: money.New(1500, "GBP"), ConfirmedAt: &confirmed}},
+			want:     []groupDebt{{From: alice, To: bob, Amount: money.New(500, "GBP")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aliceID, bobID := alice, bob
+			receipt := &models.Receipt{
+				ID:       "receipt",
+				Currency: "GBP",
+				UserID:   alice,
+				GroupID:  &groupID,
+				Items:    []models.ReceiptItem{{ID: "i1", Price: money.New(1000, "GBP"), Qty: 1}},
+				Participants: []models.Participant{
+					{ID: "p1", UserID: &aliceID},
+					{ID: "p2", UserID: &bobID, Claims: []models.ItemClaim{{ID: "c1", ReceiptItemID: "i1", Units: 1, Ways: 1}}},
+				},
+			}
+			if tt.status != "" {
+				receipt.Settlements = []models.Settlement{{ParticipantID: "p2", Amount: money.New(1000, "GBP"), Status: tt.status}}
+			}
+
+			balances, _, err := receiptBalances(receipt)
+			if err != nil {
+				t.Fatalf("receiptBalances() error = %v", err)
+			}
+			entries := groupLedgerEntries(receipt, balances, isMember)
+			if got := netGroupDebts(entries, tt.payments); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("debts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupLedgerAfterMemberLeaves(t *testing.T) {
+	const (
+		alice = "alice"
+		bob   = "bob"
+		carol = "carol"
+	)
+	groupID := "group"
+	aliceID, bobID, carolID := alice, bob, carol
+	receipt := &models.Receipt{
+		ID:       "receipt",
+		Currency: "GBP",
+		UserID:   alice,
+		GroupID:  &groupID,
+		Items: []models.ReceiptItem{
+			{ID: "i1", Price: money.New(1000, "GBP"), Qty: 1},
+			{ID: "i2", Price: money.New(600, "GBP"), Qty: 1},
+		},
+		Participants: []models.Participant{
+			{ID: "p1", UserID: &aliceID},
+			{ID: "p2", UserID: &bobID, Claims: []models.ItemClaim{{ID: "c1", ReceiptItemID: "i1", Units: 1, Ways: 1}}},
+			{ID: "p3", UserID: &carolID, Claims: []models.ItemClaim{{ID: "c2", ReceiptItemID: "i2", Units: 1, Ways: 1}}},
+		},
+	}
+	confirmed := time.Now()
+	payments := []models.GroupPayment{{FromID: bob, ToID: alice, Amount: money.New(1000, "GBP"), ConfirmedAt: &confirmed}}
+
+	balances, _, err := receiptBalances(receipt)
+	if err != nil {
+		t.Fatalf("receiptBalances() error = %v", err)
+	}
+	ledger := groupLedgerEntries(receipt, balances, map[string]bool{alice: true, bob: true, carol: true})
+	for i := range ledger {
+		ledger[i].ID = ledger[i].DebtorID
+	}
+
+	// Bob settles up and leaves, then alice edits the receipt
+	isMember := map[string]bool{alice: true, carol: true}
+	receipt.Items[0].Price = money.New(1500, "GBP")
+	receipt.Items[1].Price = money.New(900, "GBP")
+	balances, _, err = receiptBalances(receipt)
+	if err != nil {
+		t.Fatalf("receiptBalances() error = %v", err)
+	}
+
+	stale := staleLedgerEntries(receipt, ledger, isMember)
+	if want := []string{carol}; !reflect.DeepEqual(stale, want) {
+		t.Fatalf("stale = %v, want %v", stale, want)
+	}
+	var kept []models.GroupLedgerEntry
+	for _, e := range ledger {
+		if e.ID != carol {
+			kept = append(kept, e)
+		}
+	}
+	kept = append(kept, groupLedgerEntries(receipt, balances, isMember)...)
+
+	want := []groupDebt{{From: carol, To: alice, Amount: money.New(900, "GBP")}}
+	if got := netGroupDebts(kept, payments); !reflect.DeepEqual(got, want) {
+		t.Errorf("debts = %+v, want %+v", got, want)
+	}
+
+	// Taking the receipt out of the group clears everything
+	receipt.GroupID = nil
+	if got := staleLedgerEntries(receipt, ledger, isMember); len(got) != len(ledger) {
+		t.Errorf("stale = %v, want all %d entries", got, len(ledger))
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/documents"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/storage"
+
+	"gorm.io/gorm/clause"
+)
+
+var blobs storage.Store
+
+// InitStorage sets the store that keeps original receipt images
+func InitStorage(store storage.Store) {
+	blobs = store
+}
+
+// storeParseFiles keeps the files uploaded for a parse job, with thumbnails,
+// so they can be attached to the receipt created from it. Retried jobs
+// overwrite what an earlier attempt stored.
+func storeParseFiles(ctx context.Context, job *models.ParseJob, file []byte, page models.ParseJobPage) error {
+	if blobs == nil {
+		return nil
+	}
+
+	key := fmt.Sprintf("receipts/%s/%s/%d", job.UserID, job.ID, page.Position)
+	if err := blobs.Put(ctx, key, page.ContentType, file); err != nil {
+		return err
+	}
+
+	image := models.ReceiptImage{
+		UserID:      job.UserID,
+		ParseJobID:  &job.ID,
+		Position:    page.Position,
+		ContentType: page.ContentType,
+		Size:        len(file),
+		Key:         key,
+	}
+
+	// A missing thumbnail isn't worth failing the parse over
+	thumbnail, err := documents.Thumbnail(ctx, page.ContentType, file)
+	if err == nil {
+		err = blobs.Put(ctx, key+"-thumb", "image/jpeg", thumbnail)
+	}
+	if err != nil {
+		log.Printf("Failed to make thumbnail for parse job %s: %v", job.ID, err)
+	} else {
+		image.ThumbnailKey = key + "-thumb"
+	}
+
+	return db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "parse_job_id"}, {Name: "position"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content_type", "size", "key", "thumbnail_key"}),
+	}).Create(&image).Error
+}
+
+// unattachedImageTTL is how long images from a parse job are kept waiting
+// for a receipt to be created from it
+const unattachedImageTTL = 7 * 24 * time.Hour
+
+// StartImageCleanup periodically deletes images stored for parse jobs that
+// no receipt was created from, until ctx is done
+func StartImageCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := deleteUnattachedImages(ctx, time.Now().Add(-unattachedImageTTL)); err != nil {
+				log.Printf("Failed to clean up receipt images: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// deleteUnattachedImages deletes images created before cutoff that were never
+// attached to a receipt, along with their blobs
+func deleteUnattachedImages(ctx context.Context, cutoff time.Time) error {
+	if blobs == nil {
+		return nil
+	}
+
+	// Only rows still unattached are deleted and returned, so an image a
+	// receipt claims meanwhile keeps its blobs
+	var images []models.ReceiptImage
+	err := db.DB.WithContext(ctx).Clauses(clause.Returning{}).
+		Where("receipt_id IS NULL AND created_at < ?", cutoff).
+		Delete(&images).Error
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		for _, key := range []string{image.Key, image.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := blobs.Delete(ctx, key); err != nil {
+				log.Printf("Failed to delete blob %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// attachParseImages gives a new receipt the images stored when it was parsed
+func attachParseImages(receiptID, userID, parseJobID string) error {
+	return db.DB.Model(&models.ReceiptImage{}).
+		Where("parse_job_id = ? AND user_id = ? AND receipt_id IS NULL", parseJobID, userID).
+		Update("receipt_id", receiptID).Error
+}
+
+// GetReceiptImageHandler serves the original image of a receipt. ?page=
+// picks one of several photos (starting at 1) and ?size=thumbnail serves a
+// small JPEG preview instead.
+func GetReceiptImageHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	page := 1
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Page must be a positive number")
+			return
+		}
+		page = n
+	}
+	size := r.URL.Query().Get("size")
+	if size != "" && size != "original" && size != "thumbnail" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Size must be original or thumbnail")
+		return
+	}
+
+	var images []models.ReceiptImage
+	if err := db.DB.Where("receipt_id = ?", receiptID).Order("position").Find(&images).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve receipt image")
+		return
+	}
+	if page > len(images) || blobs == nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Receipt image not found")
+		return
+	}
+	image := images[page-1]
+
+	key := image.Key
+	if size == "thumbnail" {
+		if image.ThumbnailKey == "" {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "No thumbnail for this image")
+			return
+		}
+		key = image.ThumbnailKey
+	}
+
+	data, contentType, err := blobs.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Receipt image not found")
+			return
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve receipt image")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	// The content type came with the upload, so stop browsers sniffing or
+	// running anything in the file
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Image-Count", strconv.Itoa(len(images)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
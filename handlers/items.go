@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/split"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// editError is a failed edit to report to the client as is
+type editError struct {
+	status  int
+	message string
+}
+
+func (e *editError) Error() string { return e.message }
+
+// writeEditError reports an error from an edit transaction
+func writeEditError(w http.ResponseWriter, err error, fallback string) {
+	var edit *editError
+	switch {
+	case errors.As(err, &edit):
+		helpers.JSONErrorResponse(w, edit.status, edit.message)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Not found")
+	default:
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+// validateItem returns what is wrong with an item, if anything
+func validateItem(item models.ReceiptItem) string {
+	if item.Item == "" {
+		return "Item name is required"
+	}
+	if item.Qty < 1 {
+		return "Item quantity must be at least 1"
+	}
+	return ""
+}
+
+// checkClaimedQty stops an item's quantity dropping below what participants
+// have already claimed of it
+func checkClaimedQty(tx *gorm.DB, item models.ReceiptItem) error {
+	var claims []models.ItemClaim
+	if err := tx.Where("receipt_item_id = ?", item.ID).Find(&claims).Error; err != nil {
+		return err
+	}
+	if split.Remaining(item, claims).Sign() < 0 {
+		return &editError{http.StatusConflict, "Quantity of " + item.Item + " is less than has already been claimed"}
+	}
+	return nil
+}
+
+// lockReceipt locks a receipt for the rest of a transaction and returns it
+func lockReceipt(tx *gorm.DB, receiptID string) (*models.Receipt, error) {
+	var receipt models.Receipt
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&receipt, "id = ?", receiptID).Error
+	return &receipt, err
+}
+
+// AddItemHandler adds an item to a receipt
+func AddItemHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var item models.ReceiptItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if msg := validateItem(item); msg != "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		receipt, err := lockReceipt(tx, receiptID)
+		if err != nil {
+			return err
+		}
+		if item.Price, err = resolveAmount(item.Price, receipt.Currency); err != nil {
+			return err
+		}
+
+		item.ID = ""
+		item.ReceiptID = receipt.ID
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		return refreshReceiptTotals(tx, receipt.ID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to add item")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusCreated, item)
+}
+
+// UpdateItemHandler changes an item's name, price or quantity
+func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var input struct {
+		Item  *string      `json:"item"`
+		Price *money.Money `json:"price"`
+		Qty   *int         `json:"qty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	itemID := mux.Vars(r)["itemId"]
+	if _, err := uuid.Parse(itemID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Item not found")
+		return
+	}
+
+	var item models.ReceiptItem
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		receipt, err := lockReceipt(tx, receiptID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("id = ? AND receipt_id = ?", itemID, receipt.ID).First(&item).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &editError{http.StatusNotFound, "Item not found"}
+			}
+			return err
+		}
+
+		if input.Item != nil {
+			item.Item = *input.Item
+		}
+		if input.Price != nil {
+			if item.Price, err = resolveAmount(*input.Price, receipt.Currency); err != nil {
+				return err
+			}
+		}
+		if input.Qty != nil {
+			item.Qty = *input.Qty
+		}
+		if msg := validateItem(item); msg != "" {
+			return &editError{http.StatusBadRequest, msg}
+		}
+		if err := checkClaimedQty(tx, item); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&item).Select("item", "price_amount", "price_currency", "qty").Updates(&item).Error; err != nil {
+			return err
+		}
+		return refreshReceiptTotals(tx, receipt.ID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to update item")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, item)
+}
+
+// DeleteItemHandler removes an item from a receipt, along with any claims
+// on it
+func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	itemID := mux.Vars(r)["itemId"]
+	if _, err := uuid.Parse(itemID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Item not found")
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockReceipt(tx, receiptID); err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND receipt_id = ?", itemID, receiptID).Delete(&models.ReceiptItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &editError{http.StatusNotFound, "Item not found"}
+		}
+		return refreshReceiptTotals(tx, receiptID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to delete item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddModifierHandler adds a modifier to a receipt
+func AddModifierHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var modifier models.Modifier
+	if err := json.NewDecoder(r.Body).Decode(&modifier); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if modifier.Type == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Modifier type is required")
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		receipt, err := lockReceipt(tx, receiptID)
+		if err != nil {
+			return err
+		}
+		if modifier.Value, err = resolveAmount(modifier.Value, receipt.Currency); err != nil {
+			return err
+		}
+
+		modifier.ID = ""
+		modifier.ReceiptID = receipt.ID
+		if err := tx.Create(&modifier).Error; err != nil {
+			return err
+		}
+		return refreshReceiptTotals(tx, receipt.ID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to add modifier")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusCreated, modifier)
+}
+
+// UpdateModifierHandler changes a modifier. Sending "percentage": null
+// removes its percentage.
+func UpdateModifierHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var input struct {
+		Type       *string         `json:"type"`
+		Value      *money.Money    `json:"value"`
+		Percentage json.RawMessage `json:"percentage"`
+		Include    *bool           `json:"include"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	modifierID := mux.Vars(r)["modifierId"]
+	if _, err := uuid.Parse(modifierID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Modifier not found")
+		return
+	}
+
+	var modifier models.Modifier
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		receipt, err := lockReceipt(tx, receiptID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("id = ? AND receipt_id = ?", modifierID, receipt.ID).First(&modifier).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &editError{http.StatusNotFound, "Modifier not found"}
+			}
+			return err
+		}
+
+		if input.Type != nil {
+			if *input.Type == "" {
+				return &editError{http.StatusBadRequest, "Modifier type is required"}
+			}
+			modifier.Type = *input.Type
+		}
+		if input.Value != nil {
+			if modifier.Value, err = resolveAmount(*input.Value, receipt.Currency); err != nil {
+				return err
+			}
+		}
+		if len(input.Percentage) > 0 {
+			modifier.Percentage = nil
+			if string(input.Percentage) != "null" {
+				var p money.Percent
+				if err := json.Unmarshal(input.Percentage, &p); err != nil {
+					return &editError{http.StatusBadRequest, "Invalid percentage"}
+				}
+				modifier.Percentage = &p
+			}
+		}
+		if input.Include != nil {
+			modifier.Include = *input.Include
+		}
+
+		if err := tx.Model(&modifier).Select("type", "value_amount", "value_currency", "percentage", "include").Updates(&modifier).Error; err != nil {
+			return err
+		}
+		return refreshReceiptTotals(tx, receipt.ID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to update modifier")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, modifier)
+}
+
+// DeleteModifierHandler removes a modifier from a receipt
+func DeleteModifierHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	modifierID := mux.Vars(r)["modifierId"]
+	if _, err := uuid.Parse(modifierID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Modifier not found")
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockReceipt(tx, receiptID); err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND receipt_id = ?", modifierID, receiptID).Delete(&models.Modifier{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &editError{http.StatusNotFound, "Modifier not found"}
+		}
+		return refreshReceiptTotals(tx, receiptID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to delete modifier")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveAmount fills in or checks an amount's currency against the
+// receipt's
+func resolveAmount(amount money.Money, currency string) (money.Money, error) {
+	resolved, err := amount.Resolve(currency)
+	if err != nil {
+		return money.Money{}, &editError{http.StatusBadRequest, "Invalid amount: " + err.Error()}
+	}
+	if resolved.Currency != currency {
+		return money.Money{}, &editError{http.StatusBadRequest, "Amounts must be in the receipt's currency, " + currency}
+	}
+	return resolved, nil
+}
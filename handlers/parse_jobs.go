@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/documents"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/jobs"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/ocr"
+	"receipt-splitter-backend/parsing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+var (
+	parseJobs  *jobs.Pool
+	ocrClient  ocr.Provider
+	structurer parsing.ReceiptStructurer
+)
+
+// InitParseJobs sets the worker pool that parses receipts in the background
+func InitParseJobs(pool *jobs.Pool) {
+	parseJobs = pool
+}
+
+// InitOCR sets the provider used to read text from receipt images
+func InitOCR(provider ocr.Provider) {
+	ocrClient = provider
+}
+
+// InitStructurer sets the structurer that turns receipt text into items and
+// modifiers
+func InitStructurer(s parsing.ReceiptStructurer) {
+	structurer = s
+}
+
+// ProcessParseJob reads the text from a job's receipt files, stitches
+// photos of one long receipt together and structures the result. It is run
+// by the parse job workers.
+func ProcessParseJob(ctx context.Context, job *models.ParseJob) (interface{}, error) {
+	if ocrClient == nil || structurer == nil {
+		return nil, jobs.Permanent(errors.New("receipt parsing not configured"))
+	}
+
+	var pages []models.ParseJobPage
+	if err := db.DB.Where("parse_job_id = ?", job.ID).Order("position").Find(&pages).Error; err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, jobs.Permanent(errors.New("no receipt files"))
+	}
+
+	// Text read from each uploaded file, keeping its upload position
+	type pageText struct {
+		position int
+		text     string
+		photo    bool
+	}
+	texts := []pageText{}
+	for _, page := range pages {
+		file, err := base64.StdEncoding.DecodeString(page.Input)
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		if err := storeParseFiles(ctx, job, file, page); err != nil {
+			return nil, err
+		}
+
+		document, err := documents.Prepare(ctx, page.ContentType, file)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, jobs.Permanent(err)
+		}
+
+		// PDFs with embedded text don't need OCR
+		if document.Text != "" {
+			texts = append(texts, pageText{page.Position, document.Text, false})
+			continue
+		}
+		var read []string
+		for _, image := range document.Images {
+			text, err := ocrClient.Text(ctx, image)
+			if errors.Is(err, ocr.ErrNoText) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			read = append(read, text)
+		}
+		if len(read) > 0 {
+			texts = append(texts, pageText{page.Position, strings.Join(read, "\n"), page.ContentType != documents.TypePDF})
+		}
+	}
+	if len(texts) == 0 {
+		return nil, jobs.Permanent(ocr.ErrNoText)
+	}
+
+	// Consecutive photos may be overlapping shots of one long receipt, so
+	// are stitched together. A PDF's pages are separate pages, so it's kept
+	// as it is.
+	var parts []string
+	var unmatched []int
+	for i := 0; i < len(texts); {
+		j := i + 1
+		for texts[i].photo && j < len(texts) && texts[j].photo {
+			j++
+		}
+		run := make([]string, 0, j-i)
+		for _, t := range texts[i:j] {
+			run = append(run, t.text)
+		}
+		stitched, overlaps := ocr.Stitch(run)
+		for k, overlapped := range overlaps {
+			if !overlapped {
+				unmatched = append(unmatched, texts[i+k].position)
+			}
+		}
+		parts = append(parts, stitched)
+		i = j
+	}
+
+	parsed, err := structurer.Structure(ctx, strings.Join(parts, "\n"))
+	if errors.Is(err, parsing.ErrNoReceipt) {
+		return nil, jobs.Permanent(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, position := range unmatched {
+		parsed.Warn(fmt.Sprintf("pages[%d]", position), "doesn't overlap the photo before; check for missing or repeated items")
+	}
+	return parsed, nil
+}
+
+// ParseReceiptHandler queues a receipt to be parsed and returns the job to
+// poll. A receipt can be an image or a PDF, or several photos of one long
+// receipt in order. If a webhook URL is given the finished job is also
+// posted there.
+func ParseReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	files, webhookURL, ok := readReceiptUpload(w, r)
+	if !ok {
+		return
+	}
+
+	pages := make([]models.ParseJobPage, 0, len(files))
+	for i, file := range files {
+		contentType, err := documents.Sniff(file)
+		if err != nil {
+			helpers.JSONErrorResponse(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Receipt file %d: %v", i+1, err))
+			return
+		}
+		pages = append(pages, models.ParseJobPage{
+			Position:    i,
+			ContentType: contentType,
+			Input:       base64.StdEncoding.EncodeToString(file),
+		})
+	}
+
+	if webhookURL != "" {
+		if err := jobs.ValidateWebhookURL(r.Context(), webhookURL); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if parseJobs == nil || ocrClient == nil || structurer == nil {
+		helpers.JSONErrorResponse(w, http.StatusServiceUnavailable, "Receipt parsing is not available")
+		return
+	}
+
+	job := models.ParseJob{
+		UserID:     userID,
+		Status:     jobs.StatusQueued,
+		Pages:      pages,
+		WebhookURL: webhookURL,
+	}
+	if err := db.DB.Create(&job).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to create parse job")
+		return
+	}
+	if err := parseJobs.Enqueue(job.ID); err != nil {
+		// Drop the job rather than leave the caller polling it for a while
+		if err := db.DB.Delete(&job).Error; err != nil {
+			log.Printf("Failed to delete parse job %s: %v", job.ID, err)
+		}
+		w.Header().Set("Retry-After", "30")
+		helpers.JSONErrorResponse(w, http.StatusServiceUnavailable, "Too many receipts are being parsed, try again shortly")
+		return
+	}
+
+	w.Header().Set("Location", "/parse-jobs/"+job.ID)
+	helpers.JSONResponse(w, http.StatusAccepted, map[string]interface{}{
+		"job_id":     job.ID,
+		"status":     job.Status,
+		"pages":      len(pages),
+		"poll_url":   "/parse-jobs/" + job.ID,
+		"created_at": job.CreatedAt,
+	})
+}
+
+// readReceiptUpload reads the receipt files, in order, from either a
+// multipart form with one or more "receipt" file fields, or a JSON body with
+// base64 encoded files (as data URLs or bare) in "receipt" or "receipts". It
+// also returns the optional webhook URL.
+func readReceiptUpload(w http.ResponseWriter, r *http.Request) ([][]byte, string, bool) {
+	// Leave room for base64's overhead and the rest of the body
+	r.Body = http.MaxBytesReader(w, r.Body, documents.MaxTotalSize*4/3+64<<10)
+
+	tooLarge := func(err error) bool {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			helpers.JSONErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Receipts must total %d MB or less", documents.MaxTotalSize>>20))
+			return true
+		}
+		return false
+	}
+
+	var files [][]byte
+	var webhookURL string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(documents.MaxSize); err != nil {
+			if !tooLarge(err) {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid multipart form")
+			}
+			return nil, "", false
+		}
+		headers := r.MultipartForm.File["receipt"]
+		if len(headers) > documents.MaxPages {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("At most %d receipt files can be sent", documents.MaxPages))
+			return nil, "", false
+		}
+		for _, header := range headers {
+			part, err := header.Open()
+			if err != nil {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, "Failed to read receipt file")
+				return nil, "", false
+			}
+			file, err := io.ReadAll(io.LimitReader(part, documents.MaxSize+1))
+			part.Close()
+			if err != nil {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, "Failed to read receipt file")
+				return nil, "", false
+			}
+			files = append(files, file)
+		}
+		webhookURL = r.FormValue("webhook_url")
+	} else {
+		var req struct {
+			Receipt    string   `json:"receipt"`
+			Receipts   []string `json:"receipts"`
+			WebhookURL string   `json:"webhook_url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if !tooLarge(err) {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+			}
+			return nil, "", false
+		}
+
+		encoded := req.Receipts
+		if req.Receipt != "" {
+			encoded = append([]string{req.Receipt}, encoded...)
+		}
+		if len(encoded) > documents.MaxPages {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("At most %d receipt files can be sent", documents.MaxPages))
+			return nil, "", false
+		}
+		for i, data := range encoded {
+			// Strip a data URL prefix of any type, e.g. "data:application/pdf;base64,"
+			if strings.HasPrefix(data, "data:") {
+				if j := strings.Index(data, ","); j >= 0 {
+					data = data[j+1:]
+				}
+			}
+
+			file, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Receipt file %d: invalid Base64 data", i+1))
+				return nil, "", false
+			}
+			files = append(files, file)
+		}
+		webhookURL = req.WebhookURL
+	}
+
+	if len(files) == 0 {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Missing receipt file")
+		return nil, "", false
+	}
+	for i, file := range files {
+		if len(file) == 0 {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Receipt file %d is empty", i+1))
+			return nil, "", false
+		}
+		if len(file) > documents.MaxSize {
+			helpers.JSONErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Receipt file %d: %v", i+1, documents.ErrTooLarge))
+			return nil, "", false
+		}
+	}
+	return files, webhookURL, true
+}
+
+// GetParseJobHandler returns a parse job's status, and its result once it
+// has finished
+func GetParseJobHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(id); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Parse job not found")
+		return
+	}
+
+	var job models.ParseJob
+	err := db.DB.Where("id = ? AND user_id = ?", id, userID).First(&job).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Parse job not found")
+			return
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve parse job")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, job)
+}
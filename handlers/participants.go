@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/payments"
+	"receipt-splitter-backend/split"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errOverClaimed = errors.New("claim exceeds the unclaimed quantity")
+
+// participantSecretHeader carries the secret a guest was given on joining
+const participantSecretHeader = "X-Participant-Secret"
+
+// findParticipant loads a participant on the given receipt, writing an error
+// response and returning false if it cannot be found
+func findParticipant(w http.ResponseWriter, receiptID, participantID string) (*models.Participant, bool) {
+	var participant models.Participant
+	err := db.DB.Preload("Claims").Where("id = ? AND receipt_id = ?", participantID, receiptID).First(&participant).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Participant not found")
+			return nil, false
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve participant")
+		return nil, false
+	}
+	return &participant, true
+}
+
+// authorizeParticipant checks the request may act as a participant: the
+// receipt owner may act for anyone, a signed-in participant only as
+// themselves, and a guest only with the secret they were given on joining.
+// It writes an error response and returns false otherwise.
+func authorizeParticipant(w http.ResponseWriter, r *http.Request, participant *models.Participant) bool {
+	if accessFromContext(r.Context()).Owner {
+		return true
+	}
+	if participant.UserID != nil {
+		if userID, ok := auth.GetUserIDFromContext(r.Context()); ok && userID == *participant.UserID {
+			return true
+		}
+	} else if secret := r.Header.Get(participantSecretHeader); secret != "" && participant.SecretHash != "" &&
+		subtle.ConstantTimeCompare([]byte(hashShareToken(secret)), []byte(participant.SecretHash)) == 1 {
+		return true
+	}
+	helpers.JSONErrorResponse(w, http.StatusForbidden, "You can only act as yourself")
+	return false
+}
+
+// JoinReceiptHandler adds a participant to a receipt. Guests only need a
+// name, and are given a secret they send back in the X-Participant-Secret
+// header to act as that participant; signed-in users are linked to their
+// account instead.
+func JoinReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var input struct {
+		Name               string `json:"name"`
+		SettlementCurrency string `json:"settlement_currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.SettlementCurrency != "" {
+		var err error
+		if input.SettlementCurrency, err = money.NormaliseCurrency(input.SettlementCurrency); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Unknown settlement currency")
+			return
+		}
+	}
+
+	receipt, ok := findReceipt(w, receiptID)
+	if !ok {
+		return
+	}
+
+	participant := models.Participant{ReceiptID: receipt.ID, Name: input.Name, SettlementCurrency: input.SettlementCurrency}
+	if userID, ok := auth.GetUserIDFromContext(r.Context()); ok {
+		// Joining twice returns the existing participant
+		var existing models.Participant
+		err := db.DB.Where("receipt_id = ? AND user_id = ?", receipt.ID, userID).First(&existing).Error
+		if err == nil {
+			helpers.JSONResponse(w, http.StatusOK, existing)
+			return
+		}
+		if err != gorm.ErrRecordNotFound {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to query participants")
+			return
+		}
+
+		if participant.Name == "" {
+			var user models.User
+			if err := db.DB.Where("id = ?", userID).First(&user).Error; err == nil {
+				participant.Name = user.Name
+			}
+		}
+		participant.UserID = &userID
+	}
+
+	if participant.Name == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	// The secret is only returned here; just its hash is stored
+	var secret string
+	if participant.UserID == nil {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to generate secret")
+			return
+		}
+		secret = base64.RawURLEncoding.EncodeToString(raw)
+		participant.SecretHash = hashShareToken(secret)
+	}
+
+	if err := db.DB.Create(&participant).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to join receipt")
+		return
+	}
+
+	if secret == "" {
+		helpers.JSONResponse(w, http.StatusCreated, participant)
+		return
+	}
+	helpers.JSONResponse(w, http.StatusCreated, struct {
+		models.Participant
+		Secret string `json:"participant_secret"`
+	}{participant, secret})
+}
+
+// UpdateParticipantHandler changes a participant's name, settlement currency
+// or preferred payment method
+func UpdateParticipantHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var input struct {
+		Name               *string `json:"name"`
+		SettlementCurrency *string `json:"settlement_currency"`
+		PaymentMethod      *string `json:"payment_method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	participant, ok := findParticipant(w, accessFromContext(r.Context()).ReceiptID, vars["participantId"])
+	if !ok || !authorizeParticipant(w, r, participant) {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Name cannot be empty")
+			return
+		}
+		updates["name"] = name
+	}
+	if input.SettlementCurrency != nil {
+		currency := ""
+		if *input.SettlementCurrency != "" {
+			var err error
+			if currency, err = money.NormaliseCurrency(*input.SettlementCurrency); err != nil {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, "Unknown settlement currency")
+				return
+			}
+		}
+		updates["settlement_currency"] = currency
+	}
+	if input.PaymentMethod != nil {
+		if *input.PaymentMethod != "" {
+			if _, err := payments.Get(*input.PaymentMethod); err != nil {
+				helpers.JSONErrorResponse(w, http.StatusBadRequest, "Unknown payment method type")
+				return
+			}
+		}
+		updates["payment_method"] = *input.PaymentMethod
+	}
+
+	if len(updates) > 0 {
+		if err := db.DB.Model(participant).Updates(updates).Error; err != nil {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to update participant")
+			return
+		}
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, participant)
+}
+
+// GetParticipantsHandler lists a receipt's participants and their claims
+func GetParticipantsHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	receipt, ok := findReceipt(w, receiptID, "Participants.Claims")
+	if !ok {
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, receipt.Participants)
+}
+
+// ClaimItemHandler records a participant claiming some of a receipt item
+func ClaimItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var input struct {
+		ItemID string `json:"item_id"`
+		Units  *int   `json:"units"`
+		Ways   *int   `json:"ways"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	claim := models.ItemClaim{ReceiptItemID: input.ItemID, Units: 1, Ways: 1}
+	if input.Units != nil {
+		claim.Units = *input.Units
+	}
+	if input.Ways != nil {
+		claim.Ways = *input.Ways
+	}
+	if claim.ReceiptItemID == "" || claim.Units < 1 || claim.Ways < 1 {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Item ID is required, and units and ways must be at least 1")
+		return
+	}
+
+	participant, ok := findParticipant(w, accessFromContext(r.Context()).ReceiptID, vars["participantId"])
+	if !ok || !authorizeParticipant(w, r, participant) {
+		return
+	}
+	claim.ParticipantID = participant.ID
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the item so concurrent claims can't over-claim it
+		var item models.ReceiptItem
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND receipt_id = ?", claim.ReceiptItemID, participant.ReceiptID).
+			First(&item).Error
+		if err != nil {
+			return err
+		}
+
+		var claims []models.ItemClaim
+		if err := tx.Where("receipt_item_id = ?", item.ID).Find(&claims).Error; err != nil {
+			return err
+		}
+
+		remaining := split.Remaining(item, claims)
+		if remaining.Cmp(big.NewRat(int64(claim.Units), int64(claim.Ways))) < 0 {
+			return errOverClaimed
+		}
+
+		return tx.Create(&claim).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Item not found")
+		case errOverClaimed:
+			helpers.JSONErrorResponse(w, http.StatusConflict, "Claim exceeds the unclaimed quantity")
+		default:
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to claim item")
+		}
+		return
+	}
+	refreshTotalsAfter(participant.ReceiptID)
+
+	helpers.JSONResponse(w, http.StatusCreated, claim)
+}
+
+// UnclaimItemHandler removes one of a participant's claims
+func UnclaimItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	participant, ok := findParticipant(w, accessFromContext(r.Context()).ReceiptID, vars["participantId"])
+	if !ok || !authorizeParticipant(w, r, participant) {
+		return
+	}
+
+	result := db.DB.Where("id = ? AND participant_id = ?", vars["claimId"], participant.ID).Delete(&models.ItemClaim{})
+	if result.Error != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to remove claim")
+		return
+	}
+	if result.RowsAffected == 0 {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Claim not found")
+		return
+	}
+	refreshTotalsAfter(participant.ReceiptID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUnclaimedHandler lists the items on a receipt that have not been fully
+// claimed, with the remaining quantity and its value
+func GetUnclaimedHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	receipt, ok := findReceipt(w, receiptID, "Items", "Modifiers", "Participants.Claims")
+	if !ok {
+		return
+	}
+
+	result, err := split.Claims(*receipt, receipt.Participants)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claimsByItem := map[string][]models.ItemClaim{}
+	for _, p := range receipt.Participants {
+		for _, c := range p.Claims {
+			claimsByItem[c.ReceiptItemID] = append(claimsByItem[c.ReceiptItemID], c)
+		}
+	}
+
+	items := []map[string]interface{}{}
+	for _, item := range receipt.Items {
+		remaining := split.Remaining(item, claimsByItem[item.ID])
+		if remaining.Sign() <= 0 {
+			continue
+		}
+		qty, _ := remaining.Float64()
+		items = append(items, map[string]interface{}{
+			"id":              item.ID,
+			"item":            item.Item,
+			"qty":             item.Qty,
+			"remaining":       qty,
+			"remaining_exact": remaining.RatString(),
+			"amount":          money.New(result.Unassigned.Lines[item.ID], result.Currency),
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"items": items,
+		"total": money.New(result.Unassigned.Total, result.Currency),
+	})
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/payments"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// GetPaymentMethodsHandler lists the current user's payment methods
+func GetPaymentMethodsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var methods []models.PaymentMethod
+	if err := db.DB.Where("user_id = ?", userID).Order("created_at").Find(&methods).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch payment methods")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, methods)
+}
+
+// CreatePaymentMethodHandler adds a payment method for the current user
+func CreatePaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input struct {
+		Type        string `json:"type"`
+		Handle      string `json:"handle"`
+		AccountName string `json:"account_name"`
+		BIC         string `json:"bic"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	provider, err := payments.Get(input.Type)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Unknown payment method type")
+		return
+	}
+
+	method, err := provider.Normalise(models.PaymentMethod{
+		UserID:      userID,
+		Type:        input.Type,
+		Handle:      input.Handle,
+		AccountName: input.AccountName,
+		BIC:         input.BIC,
+	})
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.DB.Create(&method).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to store payment method")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusCreated, method)
+}
+
+// DeletePaymentMethodHandler removes one of the current user's payment methods
+func DeletePaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var method models.PaymentMethod
+		if err := tx.Where("id = ? AND user_id = ?", mux.Vars(r)["methodId"], userID).First(&method).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return &editError{http.StatusNotFound, "Payment method not found"}
+			}
+			return err
+		}
+		if err := tx.Delete(&method).Error; err != nil {
+			return err
+		}
+		if method.Type != payments.TypeMonzo {
+			return nil
+		}
+
+		// Clear the legacy Monzo ID too once the user has no Monzo method
+		// left, so it isn't migrated back into one
+		var count int64
+		if err := tx.Model(&models.PaymentMethod{}).Where("user_id = ? AND type = ?", userID, payments.TypeMonzo).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Update("monzo_id", "").Error
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to delete payment method")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/payments"
+)
+
+// GetPaymentLinksHandler returns, for each person's share of a receipt, a
+// payment request for every method the payer accepts. Amounts are in the
+// participant's settlement currency where the method allows it, otherwise in
+// the method's own currency. The payer's own share and empty shares are left
+// out.
+func GetPaymentLinksHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	receipt, ok := findReceipt(w, receiptID, "Items", "Modifiers", "Participants.Claims", "ExchangeRates", "User.PaymentMethods")
+	if !ok {
+		return
+	}
+
+	methods := payments.PayeeMethods(*receipt, receipt.User.PaymentMethods)
+	if len(methods) == 0 {
+		helpers.JSONErrorResponse(w, http.StatusUnprocessableEntity, "The payer has no payment methods")
+		return
+	}
+
+	result, ok := splitReceipt(w, r, receipt)
+	if !ok {
+		return
+	}
+
+	participants := map[string]models.Participant{}
+	for _, p := range receipt.Participants {
+		participants[p.ID] = p
+	}
+
+	reference := payments.Reference(*receipt)
+	links := []map[string]interface{}{}
+	for _, share := range result.Shares {
+		participant, isParticipant := participants[share.Person]
+		if isParticipant && participant.UserID != nil && *participant.UserID == receipt.UserID {
+			// The payer doesn't owe themselves
+			continue
+		}
+		if share.Total <= 0 {
+			continue
+		}
+
+		name := share.Person
+		currency := result.Currency
+		if isParticipant {
+			name = participant.Name
+			if participant.SettlementCurrency != "" {
+				currency = participant.SettlementCurrency
+			}
+		}
+
+		total := money.New(share.Total, result.Currency)
+		options := []payments.Link{}
+		for _, method := range methods {
+			provider, err := payments.Get(method.Type)
+			if err != nil {
+				continue
+			}
+
+			amount, err := paymentAmount(r.Context(), receipt, provider, total, currency)
+			if err != nil {
+				// No rate for this currency; offer the methods we can
+				continue
+			}
+
+			link, err := provider.Link(method, amount, reference)
+			if err != nil {
+				continue
+			}
+			options = append(options, link)
+		}
+
+		links = append(links, map[string]interface{}{
+			"person":    share.Person,
+			"name":      name,
+			"amount":    total,
+			"preferred": participant.PaymentMethod,
+			"options":   options,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"payee":     receipt.User.Name,
+		"reference": reference,
+		"links":     links,
+	})
+}
+
+// paymentAmount converts a share into the currency it should be paid in
+// through a provider: the participant's preferred currency if the provider
+// accepts it, otherwise the provider's own currency
+func paymentAmount(ctx context.Context, receipt *models.Receipt, provider payments.Provider, total money.Money, currency string) (money.Money, error) {
+	if !payments.Accepts(provider, currency) {
+		currency = provider.Currencies()[0]
+	}
+	return convertForReceipt(ctx, receipt, total, currency)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/payments"
+	"receipt-splitter-backend/split"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultQRSize = 256
+	maxQRSize     = 1024
+)
+
+// GetPaymentQRHandler renders a QR code a participant can scan to pay their
+// share, encoding an EPC069-12 SEPA transfer or a UPI payment request built
+// from the payer's account details. The method is chosen by the type query
+// parameter, else the participant's preference, else the first QR-capable
+// method the payer has. format may be png (default) or svg.
+func GetPaymentQRHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+	participantID := mux.Vars(r)["participantId"]
+
+	size := defaultQRSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 64 || n > maxQRSize {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Size must be between 64 and 1024")
+			return
+		}
+		size = n
+	}
+
+	receipt, ok := findReceipt(w, receiptID, "Items", "Modifiers", "Participants.Claims", "ExchangeRates", "User.PaymentMethods")
+	if !ok {
+		return
+	}
+
+	var participant *models.Participant
+	for i := range receipt.Participants {
+		if receipt.Participants[i].ID == participantID {
+			participant = &receipt.Participants[i]
+		}
+	}
+	if participant == nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Participant not found")
+		return
+	}
+
+	// Pick the payer's method to encode
+	requested := r.URL.Query().Get("type")
+	preferred := requested
+	if preferred == "" {
+		preferred = participant.PaymentMethod
+	}
+	var method models.PaymentMethod
+	var provider payments.QRProvider
+	for _, m := range payments.PayeeMethods(*receipt, receipt.User.PaymentMethods) {
+		p, err := payments.Get(m.Type)
+		if err != nil {
+			continue
+		}
+		qr, ok := p.(payments.QRProvider)
+		if !ok || (requested != "" && m.Type != requested) {
+			continue
+		}
+		if provider == nil || (m.Type == preferred && method.Type != preferred) {
+			method, provider = m, qr
+		}
+	}
+	if provider == nil {
+		helpers.JSONErrorResponse(w, http.StatusUnprocessableEntity, "The payer has no bank details that can be shown as a QR code")
+		return
+	}
+
+	result, err := split.Claims(*receipt, receipt.Participants)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var total money.Money
+	for _, share := range result.Shares {
+		if share.Person == participant.ID {
+			total = money.New(share.Total, result.Currency)
+		}
+	}
+	if total.Amount <= 0 {
+		helpers.JSONErrorResponse(w, http.StatusUnprocessableEntity, "Participant has nothing to pay")
+		return
+	}
+
+	currency := participant.SettlementCurrency
+	if currency == "" {
+		currency = result.Currency
+	}
+	amount, err := paymentAmount(r.Context(), receipt, provider, total, currency)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadGateway, "Failed to convert currency: "+err.Error())
+		return
+	}
+
+	payload, err := provider.QRPayload(method, amount, payments.Reference(*receipt))
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	image, contentType, err := payments.QRCode(payload, r.URL.Query().Get("format"), size)
+	if err != nil {
+		if err == payments.ErrUnsupportedFormat {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Format must be png or svg")
+			return
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to render QR code")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(image)
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// receiptSorts maps the ?sort= options to the expressions they order by.
+// The total and outstanding expressions have matching indexes, so keep them
+// in step with db.migrateReceiptIndexes.
+var receiptSorts = map[string]string{
+	"created_at":  "created_at",
+	"name":        "name",
+	"total":       "COALESCE(total, 0)",
+	"outstanding": "COALESCE(outstanding, 0)",
+}
+
+// receiptQuery is a parsed request for a page of receipts
+type receiptQuery struct {
+	limit    int
+	sort     string
+	desc     bool
+	cursor   *receiptCursor
+	after    interface{} // The cursor's sort value as the column's type
+	from, to *time.Time
+	merchant string
+	status   string
+	currency string
+	minTotal *int64
+	maxTotal *int64
+	summary  bool
+}
+
+// receiptCursor marks where the previous page ended: the sort value and ID
+// of its last receipt
+type receiptCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// parseReceiptQuery reads the receipt list parameters:
+//
+//	limit          page size, up to 100 (default 50)
+//	cursor         X-Next-Cursor from the previous page
+//	sort           created_at (default), name, total or outstanding
+//	order          asc or desc (default desc, or asc when sorting by name)
+//	from, to       creation date range, as dates or RFC 3339 times; a date
+//	               for "to" includes that whole day
+//	merchant       part of the receipt name, any case
+//	status         settled (nothing outstanding) or unsettled
+//	currency       only receipts in this currency
+//	min_total,
+//	max_total      total range in major units; only receipts in currency
+//	               (default GBP) are matched
+//	summary        true to leave out items and modifiers
+func parseReceiptQuery(values url.Values) (*receiptQuery, error) {
+	q := &receiptQuery{limit: defaultPageSize, sort: "created_at", desc: true}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.limit = n
+	}
+
+	if v := values.Get("sort"); v != "" {
+		if _, ok := receiptSorts[v]; !ok {
+			return nil, errors.New("sort must be created_at, name, total or outstanding")
+		}
+		q.sort = v
+		q.desc = v != "name"
+	}
+	switch values.Get("order") {
+	case "":
+	case "asc":
+		q.desc = false
+	case "desc":
+		q.desc = true
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if err := q.parseCursor(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if q.from, err = parseDateParam(values.Get("from"), false); err != nil {
+		return nil, fmt.Errorf("from: %v", err)
+	}
+	if q.to, err = parseDateParam(values.Get("to"), true); err != nil {
+		return nil, fmt.Errorf("to: %v", err)
+	}
+
+	q.merchant = strings.TrimSpace(values.Get("merchant"))
+
+	q.status = values.Get("status")
+	if q.status != "" && q.status != "settled" && q.status != "unsettled" {
+		return nil, errors.New("status must be settled or unsettled")
+	}
+
+	if v := values.Get("currency"); v != "" {
+		if q.currency, err = money.NormaliseCurrency(v); err != nil {
+			return nil, fmt.Errorf("currency: %v", err)
+		}
+	}
+	amount := func(name string) (*int64, error) {
+		v := values.Get(name)
+		if v == "" {
+			return nil, nil
+		}
+		if q.currency == "" {
+			q.currency = money.DefaultCurrency
+		}
+		m, err := money.Parse(v, q.currency)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return &m.Amount, nil
+	}
+	if q.minTotal, err = amount("min_total"); err != nil {
+		return nil, err
+	}
+	if q.maxTotal, err = amount("max_total"); err != nil {
+		return nil, err
+	}
+
+	q.summary = values.Get("summary") == "true"
+	return q, nil
+}
+
+// parseDateParam reads a date or RFC 3339 time. A date is the start of that
+// day, or with endOfDay, the start of the next.
+func parseDateParam(v string, endOfDay bool) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, errors.New("must be a date (2006-01-02) or RFC 3339 time")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// apply adds the filters, ordering and cursor to a query
+func (q *receiptQuery) apply(query *gorm.DB) *gorm.DB {
+	if q.from != nil {
+		query = query.Where("created_at >= ?", *q.from)
+	}
+	if q.to != nil {
+		query = query.Where("created_at < ?", *q.to)
+	}
+	if q.merchant != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.merchant)
+		query = query.Where("name ILIKE ?", "%"+escaped+"%")
+	}
+	switch q.status {
+	case "settled":
+		query = query.Where("COALESCE(outstanding, 0) = 0")
+	case "unsettled":
+		query = query.Where("outstanding > 0")
+	}
+	if q.currency != "" {
+		query = query.Where("currency = ?", q.currency)
+	}
+	if q.minTotal != nil {
+		query = query.Where("COALESCE(total, 0) >= ?", *q.minTotal)
+	}
+	if q.maxTotal != nil {
+		query = query.Where("COALESCE(total, 0) <= ?", *q.maxTotal)
+	}
+
+	expr := receiptSorts[q.sort]
+	direction, comparison := "ASC", ">"
+	if q.desc {
+		direction, comparison = "DESC", "<"
+	}
+	if q.cursor != nil {
+		// Receipt IDs break ties so no receipt is skipped or repeated
+		query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", expr, comparison), q.after, q.cursor.ID)
+	}
+	return query.Order(fmt.Sprintf("%s %s, id %s", expr, direction, direction))
+}
+
+// parseCursor decodes a cursor and converts its sort value back to the
+// column's type. The sort must already be known.
+func (q *receiptQuery) parseCursor(v string) error {
+	invalid := errors.New("invalid cursor")
+
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return invalid
+	}
+	cursor := &receiptCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return invalid
+	}
+	if _, err := uuid.Parse(cursor.ID); err != nil {
+		return invalid
+	}
+
+	switch q.sort {
+	case "created_at":
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return invalid
+		}
+		q.after = t
+	case "total", "outstanding":
+		n, err := strconv.ParseInt(cursor.Value, 10, 64)
+		if err != nil {
+			return invalid
+		}
+		q.after = n
+	default:
+		q.after = cursor.Value
+	}
+	q.cursor = cursor
+	return nil
+}
+
+// cursorAfter returns the cursor for the page after a receipt
+func (q *receiptQuery) cursorAfter(receipt models.Receipt) string {
+	cursor := receiptCursor{ID: receipt.ID}
+	switch q.sort {
+	case "created_at":
+		cursor.Value = receipt.CreatedAt.Format(time.RFC3339Nano)
+	case "name":
+		cursor.Value = receipt.Name
+	case "total":
+		cursor.Value = strconv.FormatInt(deref64(receipt.Total), 10)
+	case "outstanding":
+		cursor.Value = strconv.FormatInt(deref64(receipt.Outstanding), 10)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func deref64(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"receipt-splitter-backend/models"
+)
+
+func TestParseReceiptQueryCursor(t *testing.T) {
+	const id = "6f1c2a4e-8a3b-4c55-9d1e-2b7f0c9a1d23"
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		sort    string
+		cursor  string
+		wantErr bool
+	}{
+		{"created_at", "created_at", encode(`{"v":"2026-01-02T03:04:05.5Z","id":"` + id + `"}`), false},
+		{"name", "name", encode(`{"v":"Pizza","id":"` + id + `"}`), false},
+		{"total", "total", encode(`{"v":"1250","id":"` + id + `"}`), false},
+		{"not base64", "created_at", "!!!", true},
+		{"not JSON", "created_at", encode("nope"), true},
+		{"ID not a uuid", "name", encode(`{"v":"Pizza","id":"garbage"}`), true},
+		{"missing ID", "name", encode(`{"v":"Pizza"}`), true},
+		{"bad time", "created_at", encode(`{"v":"yesterday","id":"` + id + `"}`), true},
+		{"bad total", "total", encode(`{"v":"12.50","id":"` + id + `"}`), true},
+		{"bad outstanding", "outstanding", encode(`{"v":"","id":"` + id + `"}`), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseReceiptQuery(url.Values{"sort": {tt.sort}, "cursor": {tt.cursor}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseReceiptQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReceiptCursorRoundTrip(t *testing.T) {
+	total := int64(1250)
+	receipt := models.Receipt{
+		ID:        "6f1c2a4e-8a3b-4c55-9d1e-2b7f0c9a1d23",
+		Name:      "Pizza",
+		Total:     &total,
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 500, time.UTC),
+	}
+
+	tests := []struct {
+		sort string
+		want interface{}
+	}{
+		{"created_at", receipt.CreatedAt},
+		{"name", "Pizza"},
+		{"total", total},
+		{"outstanding", int64(0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			first, err := parseReceiptQuery(url.Values{"sort": {tt.sort}})
+			if err != nil {
+				t.Fatalf("parseReceiptQuery() error = %v", err)
+			}
+			next, err := parseReceiptQuery(url.Values{"sort": {tt.sort}, "cursor": {first.cursorAfter(receipt)}})
+			if err != nil {
+				t.Fatalf("parseReceiptQuery() error = %v", err)
+			}
+			if next.after != tt.want {
+				t.Errorf("cursor value = %v, want %v", next.after, tt.want)
+			}
+			if next.cursor.ID != receipt.ID {
+				t.Errorf("cursor ID = %q, want %q", next.cursor.ID, receipt.ID)
+			}
+		})
+	}
+}
@@ -1,206 +1,21 @@
 package handlers
 
 import (
-	"context"
-	"encoding/base64"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
+	"log"
 	"net/http"
-	"os"
-	"strings"
 
 	"receipt-splitter-backend/auth"
 	"receipt-splitter-backend/db"
 	"receipt-splitter-backend/helpers"
 	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
 
-	"github.com/gorilla/mux"
-	openai "github.com/sashabaranov/go-openai"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var openaiClient *openai.Client
-
-// Initialize OpenAI client
-func InitOpenAIClient(apiKey string) {
-	openaiClient = openai.NewClient(apiKey)
-}
-
-// Call OpenAI to parse extracted text into structured JSON
-func callOpenAIForParsing(extractedText string) (map[string]interface{}, error) {
-	if openaiClient == nil {
-		return nil, errors.New("OpenAI client not initialized")
-	}
-
-	resp, err := openaiClient.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4oLatest, // or GPT-4, if available
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role: openai.ChatMessageRoleSystem,
-					Content: `
-You are a highly intelligent receipt parsing assistant. Your task is to analyze the provided receipt text and return a structured JSON object with the following format:
-          {
-            "name": "Store Name",
-            "modifiers": [
-              {"type": "Modifier Type", "value": Value, "percentage": PercentageOfOrder (if applicable)}
-            ],
-            "items": [
-              {"item": "Item Name", "price": PricePerItem, "qty": Quantity}
-            ]
-          }
-          Important Considerations:
-          Store Name:
-          Extract the store's name from the receipt header or footer, wherever applicable.
-          Modifiers:
-          Include all price-related adjustments as separate entries in the modifiers array. Each modifier should include:
-          type: The name of the modifier (e.g., "Service Charge", "Discount").
-          value: The absolute value of the modifier (e.g., £10.00 for a discount or service charge).
-          percentage: If the modifier is a percentage of the total order, include the percentage. If not, set this field to null.
-          Items:
-          Each item should include:
-          item: The item's name, accurately extracted even if split across multiple lines.
-          price: The price per unit of the item. If the price is for multiple units, divide the total price by the quantity to calculate the per-item price. This should not include the currency, just the value.
-          qty: The quantity of the item. Ensure the correct quantity, even if quantities are specified on separate lines or implied by additional notes like "x2" or "double."
-          Handle cases where:
-          The price is listed per line (inclusive or exclusive of totals).
-          Adjustments (e.g., additions, subtractions, or discounts) are listed on sublines or as notes.
-          Format Adaptation:
-          Some receipts might have irregular formats, such as handwritten-style totals, unclear item groupings, or totals including service charges. Adapt accordingly and infer missing information where possible.
-          Tax:
-          If tax is explicitly mentioned, include it as a modifier in the modifiers array with type: "Tax". Specify the tax value and its percentage of the total (if applicable).
-          Error Handling:
-          If any field cannot be confidently extracted, provide a null value for that field in the JSON and note the reason in a separate "notes" field.
-`,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: fmt.Sprintf("Here is the extracted text from a receipt, ONLY PROVIDE ME THE JSON OBJECT NOTHING ELSE:\n\n %s", extractedText),
-				},
-			},
-		},
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	if len(resp.Choices) == 0 {
-		return nil, errors.New("no response from OpenAI")
-	}
-
-	// Extract the content of the response
-	rawContent := resp.Choices[0].Message.Content
-	fmt.Println("Raw OpenAI response:", rawContent) // Debugging
-
-	// Clean up the response to extract the JSON
-	cleanedContent := strings.TrimSpace(rawContent)
-	cleanedContent = strings.TrimPrefix(cleanedContent, "```json")
-	cleanedContent = strings.TrimPrefix(cleanedContent, "```")
-	cleanedContent = strings.TrimSuffix(cleanedContent, "```")
-
-	// Parse the cleaned JSON content
-	var structuredData map[string]interface{}
-	err = json.Unmarshal([]byte(cleanedContent), &structuredData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse OpenAI response: %v", err)
-	}
-
-	return structuredData, nil
-}
-
-// Helper functions for API calls
-func callGoogleVisionAPI(base64Image string) (string, error) {
-	apiKey := os.Getenv("GOOGLE_API_KEY")
-	url := "https://vision.googleapis.com/v1/images:annotate?key=" + apiKey
-
-	requestBody := map[string]interface{}{
-		"requests": []map[string]interface{}{
-			{
-				"image": map[string]string{"content": base64Image},
-				"features": []map[string]interface{}{
-					{"type": "TEXT_DETECTION", "maxResults": 1},
-				},
-			},
-		},
-	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := http.Post(url, "application/json", strings.NewReader(string(jsonBody)))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return "", errors.New("Google Vision API error: " + string(body))
-	}
-
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", err
-	}
-
-	responses, ok := result["responses"].([]interface{})
-	if !ok || len(responses) == 0 {
-		return "", errors.New("invalid Google Vision API response")
-	}
-
-	fullText, _ := responses[0].(map[string]interface{})["fullTextAnnotation"].(map[string]interface{})["text"].(string)
-	return fullText, nil
-}
-
-// ParseReceiptHandler processes and parses receipts
-func ParseReceiptHandler(w http.ResponseWriter, r *http.Request) {
-	type ParseRequest struct {
-		Receipt string `json:"receipt"`
-	}
-
-	var req ParseRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
-		return
-	}
-
-	base64Image := req.Receipt
-	if strings.HasPrefix(base64Image, "data:image") {
-		base64Image = strings.Split(base64Image, ",")[1]
-	}
-
-	// Decode Base64 image
-	_, err := base64.StdEncoding.DecodeString(base64Image)
-	if err != nil {
-		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid Base64 image data")
-		return
-	}
-
-	// Extract text using Google Vision API
-	extractedText, err := callGoogleVisionAPI(base64Image)
-	if err != nil {
-		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Error parsing Base64 data")
-		return
-	}
-
-	// Call OpenAI for parsing the extracted text
-	structuredData, err := callOpenAIForParsing(extractedText)
-	if err != nil {
-		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to parse receipt with OpenAI API: "+err.Error())
-		return
-	}
-
-	// Respond with the structured data
-	helpers.JSONResponse(w, http.StatusOK, structuredData)
-}
-
 func CreateReceiptHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the user ID from the context
 	userID, ok := auth.GetUserIDFromContext(r.Context())
@@ -214,13 +29,40 @@ func CreateReceiptHandler(w http.ResponseWriter, r *http.Request) {
 		Name      string               `json:"name"`
 		Reason    string               `json:"reason"`
 		MonzoID   string               `json:"monzo_id"`
+		Currency  string               `json:"currency"`
 		Items     []models.ReceiptItem `json:"items"`
 		Modifiers []models.Modifier    `json:"modifiers"`
+		// ParseJobID attaches the images stored when the receipt was parsed
+		ParseJobID string `json:"parse_job_id"`
+		// GroupID adds the receipt to one of the user's groups
+		GroupID string `json:"group_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&receiptInput); err != nil {
 		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
+	for _, item := range receiptInput.Items {
+		if msg := validateItem(item); msg != "" {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	// Fill in currencies, converting legacy decimal amounts
+	currency, err := resolveReceiptMoney(receiptInput.Currency, receiptInput.Items, receiptInput.Modifiers)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid amounts: "+err.Error())
+		return
+	}
+
+	var groupID *string
+	if receiptInput.GroupID != "" {
+		if err := checkReceiptGroup(receiptInput.GroupID, userID); err != nil {
+			writeEditError(w, err, "Failed to store receipt")
+			return
+		}
+		groupID = &receiptInput.GroupID
+	}
 
 	// Create a new receipt
 	receipt := models.Receipt{
@@ -228,8 +70,10 @@ func CreateReceiptHandler(w http.ResponseWriter, r *http.Request) {
 		Name:      receiptInput.Name,
 		Reason:    receiptInput.Reason,
 		MonzoID:   receiptInput.MonzoID,
+		Currency:  currency,
 		Items:     receiptInput.Items,
 		Modifiers: receiptInput.Modifiers,
+		GroupID:   groupID,
 	}
 
 	// Save the receipt to the database
@@ -238,19 +82,40 @@ func CreateReceiptHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	refreshTotalsAfter(receipt.ID)
+
+	if receiptInput.ParseJobID != "" {
+		if _, err := uuid.Parse(receiptInput.ParseJobID); err == nil {
+			if err := attachParseImages(receipt.ID, userID, receiptInput.ParseJobID); err != nil {
+				log.Printf("Failed to attach images to receipt %s: %v", receipt.ID, err)
+			}
+		}
+	}
+
 	// Respond with the created receipt
-	helpers.JSONResponse(w, http.StatusCreated, map[string]interface{}{
+	helpers.JSONResponse(w, http.StatusCreated, ownerReceiptData(&receipt))
+}
+
+// ownerReceiptData is a receipt as shown to its owner
+func ownerReceiptData(receipt *models.Receipt) map[string]interface{} {
+	return map[string]interface{}{
 		"id":         receipt.ID,
 		"user_id":    receipt.UserID,
 		"name":       receipt.Name,
 		"reason":     receipt.Reason,
 		"monzo_id":   receipt.MonzoID,
+		"currency":   receipt.Currency,
+		"group_id":   receipt.GroupID,
 		"items":      receipt.Items,
 		"modifiers":  receipt.Modifiers,
 		"created_at": receipt.CreatedAt,
-	})
+	}
 }
 
+// GetAllReceiptsHandler lists the user's receipts a page at a time, newest
+// first by default. See parseReceiptQuery for the filters and sorting
+// supported. The cursor for the next page, if any, is in the X-Next-Cursor
+// header. With ?summary=true items and modifiers are left out.
 func GetAllReceiptsHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from the context
 	userID, ok := auth.GetUserIDFromContext(r.Context())
@@ -259,26 +124,56 @@ func GetAllReceiptsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch all receipts for the user with associated items and modifiers
+	q, err := parseReceiptQuery(r.URL.Query())
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := q.apply(db.DB.Where("user_id = ?", userID))
+	if !q.summary {
+		query = query.Preload("Items").Preload("Modifiers")
+	}
+
+	// Fetch one extra receipt to tell whether there's another page
 	var receipts []models.Receipt
-	if err := db.DB.Preload("Items").Preload("Modifiers").Where("user_id = ?", userID).Find(&receipts).Error; err != nil {
+	if err := query.Limit(q.limit + 1).Find(&receipts).Error; err != nil {
 		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch receipts")
 		return
 	}
+	if len(receipts) > q.limit {
+		receipts = receipts[:q.limit]
+		w.Header().Set("X-Next-Cursor", q.cursorAfter(receipts[len(receipts)-1]))
+	}
 
 	// Format receipts for the response
-	var formattedReceipts []map[string]interface{}
+	formattedReceipts := make([]map[string]interface{}, 0, len(receipts))
 	for _, receipt := range receipts {
-		formattedReceipts = append(formattedReceipts, map[string]interface{}{
-			"id":         receipt.ID,
-			"user_id":    receipt.UserID,
-			"name":       receipt.Name,
-			"reason":     receipt.Reason,
-			"monzo_id":   receipt.MonzoID,
-			"items":      receipt.Items,
-			"modifiers":  receipt.Modifiers,
-			"created_at": receipt.CreatedAt,
-		})
+		var total, outstanding interface{}
+		if receipt.Total != nil {
+			total = money.New(*receipt.Total, receipt.Currency)
+		}
+		if receipt.Outstanding != nil {
+			outstanding = money.New(*receipt.Outstanding, receipt.Currency)
+		}
+
+		formatted := map[string]interface{}{
+			"id":          receipt.ID,
+			"user_id":     receipt.UserID,
+			"name":        receipt.Name,
+			"reason":      receipt.Reason,
+			"monzo_id":    receipt.MonzoID,
+			"currency":    receipt.Currency,
+			"group_id":    receipt.GroupID,
+			"total":       total,
+			"outstanding": outstanding,
+			"created_at":  receipt.CreatedAt,
+		}
+		if !q.summary {
+			formatted["items"] = receipt.Items
+			formatted["modifiers"] = receipt.Modifiers
+		}
+		formattedReceipts = append(formattedReceipts, formatted)
 	}
 
 	// Respond with the list of receipts
@@ -286,32 +181,338 @@ func GetAllReceiptsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetReceiptByIDHandler(w http.ResponseWriter, r *http.Request) {
-	// Get the receipt ID from the URL
-	id := mux.Vars(r)["id"]
+	// Get the receipt the owner or share link may access
+	access := accessFromContext(r.Context())
 
 	// Fetch the receipt, including associated items and modifiers
-	var receipt models.Receipt
-	if err := db.DB.Preload("Items").Preload("Modifiers").First(&receipt, "id = ?", id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			helpers.JSONErrorResponse(w, http.StatusNotFound, "Receipt not found")
-			return
-		}
-		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve receipt")
+	receipt, ok := findReceipt(w, access.ReceiptID, "Items", "Modifiers", "User")
+	if !ok {
 		return
 	}
 
-	// Construct the receipt data manually
+	// Construct the receipt data manually, only naming the owner to guests
 	receiptData := map[string]interface{}{
 		"id":         receipt.ID,
-		"user_id":    receipt.UserID,
+		"owner":      map[string]string{"name": receipt.User.Name},
 		"name":       receipt.Name,
 		"reason":     receipt.Reason,
 		"monzo_id":   receipt.MonzoID,
+		"currency":   receipt.Currency,
+		"group_id":   receipt.GroupID,
 		"items":      receipt.Items,
 		"modifiers":  receipt.Modifiers,
 		"created_at": receipt.CreatedAt,
 	}
+	if access.Owner {
+		receiptData["user_id"] = receipt.UserID
+	}
 
 	// Respond with the receipt
 	helpers.JSONResponse(w, http.StatusOK, receiptData)
 }
+
+// findReceipt loads a receipt with the given associations, writing an error
+// response and returning false if it cannot be found
+func findReceipt(w http.ResponseWriter, id string, preloads ...string) (*models.Receipt, bool) {
+	query := db.DB
+	for _, preload := range preloads {
+		query = query.Preload(preload)
+	}
+
+	var receipt models.Receipt
+	if err := query.First(&receipt, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Receipt not found")
+			return nil, false
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve receipt")
+		return nil, false
+	}
+	return &receipt, true
+}
+
+// resolveReceiptMoney fills in the currency of item prices and modifier
+// values sent without one, and returns the receipt's currency. Legacy decimal
+// amounts take the given currency, else that of any explicit amount on the
+// receipt, else the default. All amounts must end up in the same currency.
+func resolveReceiptMoney(currency string, items []models.ReceiptItem, modifiers []models.Modifier) (string, error) {
+	if currency != "" {
+		var err error
+		if currency, err = money.NormaliseCurrency(currency); err != nil {
+			return "", err
+		}
+	}
+	for _, item := range items {
+		if currency != "" {
+			break
+		}
+		if item.Price.Currency != "" {
+			currency = item.Price.Currency
+			break
+		}
+	}
+	for _, m := range modifiers {
+		if currency == "" && m.Value.Currency != "" {
+			currency = m.Value.Currency
+		}
+	}
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+
+	var err error
+	for i := range items {
+		if items[i].Price, err = items[i].Price.Resolve(currency); err != nil {
+			return "", err
+		}
+		if items[i].Price.Currency != currency {
+			return "", money.ErrCurrencyMismatch
+		}
+	}
+	for i := range modifiers {
+		if modifiers[i].Value, err = modifiers[i].Value.Resolve(currency); err != nil {
+			return "", err
+		}
+		if modifiers[i].Value.Currency != currency {
+			return "", money.ErrCurrencyMismatch
+		}
+	}
+	return currency, nil
+}
+
+// ReplaceReceiptHandler replaces a receipt's details, items and modifiers.
+// Items and modifiers with an ID are updated, those without are added and
+// any left out are deleted, along with claims on deleted items.
+func ReplaceReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var input struct {
+		Name      string               `json:"name"`
+		Reason    string               `json:"reason"`
+		MonzoID   string               `json:"monzo_id"`
+		Currency  string               `json:"currency"`
+		Items     []models.ReceiptItem `json:"items"`
+		Modifiers []models.Modifier    `json:"modifiers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	for _, item := range input.Items {
+		if msg := validateItem(item); msg != "" {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	var receipt models.Receipt
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the receipt so concurrent edits and claims apply one at a time
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Preload("Modifiers").
+			First(&receipt, "id = ?", receiptID).Error
+		if err != nil {
+			return err
+		}
+
+		currency := input.Currency
+		if currency == "" {
+			currency = receipt.Currency
+		}
+		if currency, err = resolveReceiptMoney(currency, input.Items, input.Modifiers); err != nil {
+			return &editError{http.StatusBadRequest, "Invalid amounts: " + err.Error()}
+		}
+		if currency != receipt.Currency {
+			var settled int64
+			tx.Model(&models.Settlement{}).Where("receipt_id = ?", receipt.ID).Count(&settled)
+			if settled > 0 {
+				return &editError{http.StatusConflict, "Can't change the currency of a receipt with settlements"}
+			}
+			// Rates recorded for the old currency no longer apply
+			if err := tx.Where("receipt_id = ?", receipt.ID).Delete(&models.ReceiptExchangeRate{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := replaceItems(tx, receipt.ID, receipt.Items, input.Items); err != nil {
+			return err
+		}
+		if err := replaceModifiers(tx, receipt.ID, receipt.Modifiers, input.Modifiers); err != nil {
+			return err
+		}
+
+		receipt.Name = input.Name
+		receipt.Reason = input.Reason
+		receipt.MonzoID = input.MonzoID
+		receipt.Currency = currency
+		receipt.Items = input.Items
+		receipt.Modifiers = input.Modifiers
+		if err := tx.Model(&receipt).Select("name", "reason", "monzo_id", "currency").Updates(&receipt).Error; err != nil {
+			return err
+		}
+		return refreshReceiptTotals(tx, receipt.ID)
+	})
+	if err != nil {
+		writeEditError(w, err, "Failed to update receipt")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, ownerReceiptData(&receipt))
+}
+
+// replaceItems brings a receipt's stored items in line with the given list
+func replaceItems(tx *gorm.DB, receiptID string, existing, items []models.ReceiptItem) error {
+	stored := map[string]bool{}
+	for _, item := range existing {
+		stored[item.ID] = true
+	}
+
+	keep := []string{}
+	for i := range items {
+		items[i].ReceiptID = receiptID
+		if items[i].ID == "" {
+			if err := tx.Create(&items[i]).Error; err != nil {
+				return err
+			}
+			keep = append(keep, items[i].ID)
+			continue
+		}
+		if !stored[items[i].ID] {
+			return &editError{http.StatusBadRequest, "Item " + items[i].ID + " is not on this receipt"}
+		}
+		if err := checkClaimedQty(tx, items[i]); err != nil {
+			return err
+		}
+		if err := tx.Model(&items[i]).Select("item", "price_amount", "price_currency", "qty").Updates(&items[i]).Error; err != nil {
+			return err
+		}
+		keep = append(keep, items[i].ID)
+	}
+
+	query := tx.Where("receipt_id = ?", receiptID)
+	if len(keep) > 0 {
+		query = query.Where("id NOT IN ?", keep)
+	}
+	return query.Delete(&models.ReceiptItem{}).Error
+}
+
+// replaceModifiers brings a receipt's stored modifiers in line with the
+// given list
+func replaceModifiers(tx *gorm.DB, receiptID string, existing, modifiers []models.Modifier) error {
+	stored := map[string]bool{}
+	for _, m := range existing {
+		stored[m.ID] = true
+	}
+
+	keep := []string{}
+	for i := range modifiers {
+		modifiers[i].ReceiptID = receiptID
+		if modifiers[i].ID == "" {
+			if err := tx.Create(&modifiers[i]).Error; err != nil {
+				return err
+			}
+			keep = append(keep, modifiers[i].ID)
+			continue
+		}
+		if !stored[modifiers[i].ID] {
+			return &editError{http.StatusBadRequest, "Modifier " + modifiers[i].ID + " is not on this receipt"}
+		}
+		if err := tx.Model(&modifiers[i]).Select("type", "value_amount", "value_currency", "percentage", "include").Updates(&modifiers[i]).Error; err != nil {
+			return err
+		}
+		keep = append(keep, modifiers[i].ID)
+	}
+
+	query := tx.Where("receipt_id = ?", receiptID)
+	if len(keep) > 0 {
+		query = query.Where("id NOT IN ?", keep)
+	}
+	return query.Delete(&models.Modifier{}).Error
+}
+
+// UpdateReceiptHandler changes a receipt's name, reason, Monzo ID or group.
+// An empty group_id takes the receipt out of its group.
+func UpdateReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var input struct {
+		Name    *string `json:"name"`
+		Reason  *string `json:"reason"`
+		MonzoID *string `json:"monzo_id"`
+		GroupID *string `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		updates["name"] = *input.Name
+	}
+	if input.Reason != nil {
+		updates["reason"] = *input.Reason
+	}
+	if input.MonzoID != nil {
+		updates["monzo_id"] = *input.MonzoID
+	}
+	if input.GroupID != nil {
+		if *input.GroupID == "" {
+			updates["group_id"] = nil
+		} else {
+			userID, _ := auth.GetUserIDFromContext(r.Context())
+			if err := checkReceiptGroup(*input.GroupID, userID); err != nil {
+				writeEditError(w, err, "Failed to update receipt")
+				return
+			}
+			updates["group_id"] = *input.GroupID
+		}
+	}
+	if len(updates) > 0 {
+		if err := db.DB.Model(&models.Receipt{}).Where("id = ?", receiptID).Updates(updates).Error; err != nil {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to update receipt")
+			return
+		}
+	}
+	if input.GroupID != nil {
+		refreshTotalsAfter(receiptID)
+	}
+
+	receipt, ok := findReceipt(w, receiptID, "Items", "Modifiers")
+	if !ok {
+		return
+	}
+	helpers.JSONResponse(w, http.StatusOK, ownerReceiptData(receipt))
+}
+
+// DeleteReceiptHandler deletes a receipt with everything attached to it,
+// including its stored images. What members owed on a group receipt stays
+// in the group's balances, since they may already have paid it back through
+// group payments; forgive their shares first to cancel it.
+func DeleteReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var images []models.ReceiptImage
+	if err := db.DB.Where("receipt_id = ?", receiptID).Find(&images).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to delete receipt")
+		return
+	}
+
+	if err := db.DB.Delete(&models.Receipt{}, "id = ?", receiptID).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to delete receipt")
+		return
+	}
+
+	// The receipt is gone either way; a leftover blob is only wasted space
+	for _, image := range images {
+		for _, key := range []string{image.Key, image.ThumbnailKey} {
+			if key == "" || blobs == nil {
+				continue
+			}
+			if err := blobs.Delete(r.Context(), key); err != nil {
+				log.Printf("Failed to delete blob %s: %v", key, err)
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
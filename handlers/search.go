@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/money"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// headlineOptions marks matched words with control characters, which
+	// highlight swaps for <mark> tags once the text is HTML escaped. Names
+	// and items are short so are highlighted whole; reasons are cut down to
+	// the matches.
+	headlineStart        = "\x01"
+	headlineStop         = "\x02"
+	headlineOptions      = "StartSel=" + headlineStart + ", StopSel=" + headlineStop + ", HighlightAll=true"
+	reasonHeadlineOption = "StartSel=" + headlineStart + ", StopSel=" + headlineStop + ", MaxWords=20, MinWords=5, MaxFragments=2"
+)
+
+// highlightReplacer turns headline markers into <mark> tags
+var highlightReplacer = strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>")
+
+// highlight makes a ts_headline result safe to render as HTML, with only the
+// <mark> tags around matched words left unescaped
+func highlight(headline string) string {
+	return highlightReplacer.Replace(html.EscapeString(headline))
+}
+
+// searchResult is a row of the receipt search query
+type searchResult struct {
+	ID              string
+	UserID          string
+	Name            string
+	Reason          string
+	Currency        string
+	Total           *int64
+	Outstanding     *int64
+	CreatedAt       time.Time
+	Rank            float64
+	NameHighlight   string
+	ReasonHighlight string
+}
+
+// itemMatch is an item whose name matched the search
+type itemMatch struct {
+	ReceiptID string
+	ID        string
+	Item      string
+	Highlight string
+}
+
+// SearchReceiptsHandler searches the receipts the user owns or is a
+// participant on by name, reason and item names. The query in ?q= accepts
+// web search syntax: quoted phrases, "or" and -excluded words. Results are
+// best match first, with HTML escaped highlights that wrap matched words in
+// <mark> tags. ?from= and ?to= limit the creation date and ?limit= and
+// ?offset= page the results.
+func SearchReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	values := r.URL.Query()
+	search := strings.TrimSpace(values.Get("q"))
+	if search == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "offset must be a non-negative number")
+			return
+		}
+		offset = n
+	}
+	from, err := parseDateParam(values.Get("from"), false)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "from: "+err.Error())
+		return
+	}
+	to, err := parseDateParam(values.Get("to"), true)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "to: "+err.Error())
+		return
+	}
+
+	query := db.DB.Table("receipts r, websearch_to_tsquery('english', ?) query", search).
+		Select(`r.id, r.user_id, r.name, r.reason, r.currency, r.total, r.outstanding, r.created_at,
+			ts_rank_cd(r.search_vector, query) AS rank,
+			ts_headline('english', r.name, query, ?) AS name_highlight,
+			ts_headline('english', coalesce(r.reason, ''), query, ?) AS reason_highlight`,
+			headlineOptions, reasonHeadlineOption).
+		Where("r.search_vector @@ query").
+		Where("r.user_id = ? OR EXISTS (SELECT 1 FROM participants p WHERE p.receipt_id = r.id AND p.user_id = ?)", userID, userID)
+	if from != nil {
+		query = query.Where("r.created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("r.created_at < ?", *to)
+	}
+
+	var results []searchResult
+	if err := query.Order("rank DESC, r.created_at DESC, r.id").Limit(limit).Offset(offset).Scan(&results).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to search receipts")
+		return
+	}
+
+	// Find which items on the matched receipts the search hit
+	items := map[string][]map[string]interface{}{}
+	if len(results) > 0 {
+		ids := make([]string, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+		var matches []itemMatch
+		err := db.DB.Table("receipt_items i, websearch_to_tsquery('english', ?) query", search).
+			Select("i.receipt_id, i.id, i.item, ts_headline('english', i.item, query, ?) AS highlight", headlineOptions).
+			Where("i.receipt_id IN ? AND to_tsvector('english', i.item) @@ query", ids).
+			Order("i.item").
+			Scan(&matches).Error
+		if err != nil {
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to search receipts")
+			return
+		}
+		for _, match := range matches {
+			items[match.ReceiptID] = append(items[match.ReceiptID], map[string]interface{}{
+				"id":        match.ID,
+				"item":      match.Item,
+				"highlight": highlight(match.Highlight),
+			})
+		}
+	}
+
+	formattedResults := make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		var total, outstanding interface{}
+		if result.Total != nil {
+			total = money.New(*result.Total, result.Currency)
+		}
+		if result.Outstanding != nil {
+			outstanding = money.New(*result.Outstanding, result.Currency)
+		}
+
+		highlights := map[string]interface{}{"name": highlight(result.NameHighlight)}
+		if strings.Contains(result.ReasonHighlight, headlineStart) {
+			highlights["reason"] = highlight(result.ReasonHighlight)
+		}
+		matchedItems := items[result.ID]
+		if matchedItems == nil {
+			matchedItems = []map[string]interface{}{}
+		}
+
+		formattedResults = append(formattedResults, map[string]interface{}{
+			"id":            result.ID,
+			"user_id":       result.UserID,
+			"owner":         result.UserID == userID,
+			"name":          result.Name,
+			"reason":        result.Reason,
+			"currency":      result.Currency,
+			"total":         total,
+			"outstanding":   outstanding,
+			"created_at":    result.CreatedAt,
+			"rank":          result.Rank,
+			"highlights":    highlights,
+			"matched_items": matchedItems,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, formattedResults)
+}
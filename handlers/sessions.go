@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// SessionActive reports whether a user's session is still active. It's the
+// check auth.JWTMiddleware runs on every token.
+func SessionActive(sessionID, userID string) (bool, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return false, nil
+	}
+	var count int64
+	err := db.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// revokeSessions ends the active sessions matching a condition, along with
+// their refresh tokens
+func revokeSessions(tx *gorm.DB, query interface{}, args ...interface{}) error {
+	var ids []string
+	if err := tx.Model(&models.Session{}).Where(query, args...).Where("revoked_at IS NULL").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).Where("id IN ?", ids).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).Where("family_id IN ? AND revoked_at IS NULL", ids).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		auth.ForgetSession(id)
+	}
+	return nil
+}
+
+// trustedProxies are the proxies whose X-Forwarded-For headers are believed
+var trustedProxies []netip.Prefix
+
+// InitTrustedProxies sets the proxies in front of the server, as IP
+// addresses or CIDR ranges, whose X-Forwarded-For headers can be trusted
+func InitTrustedProxies(proxies []string) error {
+	trustedProxies = nil
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+	return nil
+}
+
+// trustedProxy reports whether an address belongs to a trusted proxy
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address a request came from. X-Forwarded-For is only
+// believed when the request came through a trusted proxy, and then only as
+// far back as the last address a trusted proxy added, since clients can put
+// anything at the start of it.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// GetSessionsHandler lists the current user's active sessions, marking the
+// one making the request
+func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	currentID, _ := auth.GetSessionIDFromContext(r.Context())
+
+	var sessions []models.Session
+	err := db.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	formattedSessions := make([]map[string]interface{}, 0, len(sessions))
+	for _, s := range sessions {
+		formattedSessions = append(formattedSessions, map[string]interface{}{
+			"id":           s.ID,
+			"user_agent":   s.UserAgent,
+			"ip_address":   s.IPAddress,
+			"current":      s.ID == currentID,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"expires_at":   s.ExpiresAt,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, formattedSessions)
+}
+
+// DeleteSessionHandler ends one of the current user's sessions, logging that
+// device out straight away
+func DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessionID := mux.Vars(r)["sessionId"]
+	if _, err := uuid.Parse(sessionID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	var count int64
+	err := db.DB.Model(&models.Session{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Count(&count).Error
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if count == 0 {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := revokeSessions(db.DB, "id = ? AND user_id = ?", sessionID, userID); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
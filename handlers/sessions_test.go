@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	if err := InitTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatalf("InitTrustedProxies() error = %v", err)
+	}
+	t.Cleanup(func() { InitTrustedProxies(nil) })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxy", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer's header is ignored", "203.0.113.5:1234", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"trusted proxy without header", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"one trusted proxy", "10.1.2.3:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"chain of trusted proxies", "10.1.2.3:1234", []string{"198.51.100.7, 192.0.2.1, 10.9.9.9"}, "198.51.100.7"},
+		{"spoofed start of header is ignored", "10.1.2.3:1234", []string{"1.1.1.1, 198.51.100.7"}, "198.51.100.7"},
+		{"repeated headers are joined", "10.1.2.3:1234", []string{"1.1.1.1", "198.51.100.7"}, "198.51.100.7"},
+		{"garbage stops the walk", "10.1.2.3:1234", []string{"198.51.100.7, not-an-ip"}, "10.1.2.3"},
+		{"empty hops are skipped", "10.1.2.3:1234", []string{"198.51.100.7, ,"}, "198.51.100.7"},
+		{"only trusted proxies", "10.1.2.3:1234", []string{"10.4.4.4"}, "10.4.4.4"},
+		{"IPv4-mapped proxy address", "[::ffff:10.1.2.3]:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"IPv6 client", "10.1.2.3:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"remote address without port", "203.0.113.5", nil, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitTrustedProxiesRejectsGarbage(t *testing.T) {
+	t.Cleanup(func() { InitTrustedProxies(nil) })
+	if err := InitTrustedProxies([]string{"10.0.0.0/8", "proxy.internal"}); err == nil {
+		t.Error("InitTrustedProxies() accepted a hostname")
+	}
+}
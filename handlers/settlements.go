@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/split"
+
+	"github.com/gorilla/mux"
+)
+
+var settlementStatuses = map[string]bool{
+	models.SettlementPending:  true,
+	models.SettlementPaid:     true,
+	models.SettlementDisputed: true,
+	models.SettlementForgiven: true,
+}
+
+// participantBalance is what a participant owes on a receipt and how much of
+// it is still outstanding
+type participantBalance struct {
+	Participant models.Participant `json:"-"`
+	Owed        money.Money        `json:"owed"`
+	Outstanding money.Money        `json:"outstanding"`
+	Settlement  *models.Settlement `json:"settlement,omitempty"`
+}
+
+// receiptBalances works out each participant's balance on a receipt, which
+// must be loaded with its items, modifiers, participants' claims and
+// settlements. The payer's own participant is left out. It also returns the
+// total still outstanding.
+func receiptBalances(receipt *models.Receipt) ([]participantBalance, money.Money, error) {
+	result, err := split.Claims(*receipt, receipt.Participants)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	owed := map[string]int64{}
+	for _, share := range result.Shares {
+		owed[share.Person] = share.Total
+	}
+	settlements := map[string]models.Settlement{}
+	for _, s := range receipt.Settlements {
+		settlements[s.ParticipantID] = s
+	}
+
+	balances := []participantBalance{}
+	total := money.New(0, result.Currency)
+	for _, p := range receipt.Participants {
+		if p.UserID != nil && *p.UserID == receipt.UserID {
+			continue
+		}
+
+		balance := participantBalance{
+			Participant: p,
+			Owed:        money.New(owed[p.ID], result.Currency),
+			Outstanding: money.New(owed[p.ID], result.Currency),
+		}
+		if s, ok := settlements[p.ID]; ok {
+			balance.Settlement = &s
+			switch s.Status {
+			case models.SettlementForgiven:
+				balance.Outstanding.Amount = 0
+			case models.SettlementPaid:
+				// The share may have grown since it was paid
+				balance.Outstanding.Amount -= s.Amount.Amount
+			}
+		}
+		if balance.Outstanding.Amount < 0 {
+			balance.Outstanding.Amount = 0
+		}
+
+		total.Amount += balance.Outstanding.Amount
+		balances = append(balances, balance)
+	}
+	return balances, total, nil
+}
+
+// GetSettlementsHandler lists who has settled up on a receipt and what is
+// still outstanding
+func GetSettlementsHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	receipt, ok := findReceipt(w, receiptID, "Items", "Modifiers", "Participants.Claims", "Settlements")
+	if !ok {
+		return
+	}
+
+	balances, outstanding, err := receiptBalances(receipt)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	participants := make([]map[string]interface{}, 0, len(balances))
+	for _, b := range balances {
+		status := models.SettlementPending
+		if b.Settlement != nil {
+			status = b.Settlement.Status
+		}
+		participants = append(participants, map[string]interface{}{
+			"participant_id": b.Participant.ID,
+			"name":           b.Participant.Name,
+			"owed":           b.Owed,
+			"outstanding":    b.Outstanding,
+			"status":         status,
+			"settlement":     b.Settlement,
+		})
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"participants": participants,
+		"outstanding":  outstanding,
+	})
+}
+
+// saveSettlement creates or updates a participant's settlement. update
+// applies the caller's changes given what the participant currently owes.
+func saveSettlement(w http.ResponseWriter, receipt *models.Receipt, participantID string, update func(s *models.Settlement, owed money.Money)) (*models.Settlement, bool) {
+	balances, _, err := receiptBalances(receipt)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	var balance *participantBalance
+	for i := range balances {
+		if balances[i].Participant.ID == participantID {
+			balance = &balances[i]
+		}
+	}
+	if balance == nil {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Participant not found")
+		return nil, false
+	}
+
+	settlement := models.Settlement{
+		ReceiptID:     receipt.ID,
+		ParticipantID: participantID,
+		Status:        models.SettlementPending,
+	}
+	if balance.Settlement != nil {
+		settlement = *balance.Settlement
+	}
+	if settlement.Amount.Currency == "" {
+		settlement.Amount = balance.Owed
+	}
+	update(&settlement, balance.Owed)
+
+	if err := db.DB.Save(&settlement).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to save settlement")
+		return nil, false
+	}
+	refreshTotalsAfter(receipt.ID)
+	return &settlement, true
+}
+
+// ReportPaidHandler lets a participant say they have paid their share. The
+// settlement stays pending until the payer confirms it.
+func ReportPaidHandler(w http.ResponseWriter, r *http.Request) {
+	receiptID := accessFromContext(r.Context()).ReceiptID
+
+	var input struct {
+		Method string `json:"method"`
+		Note   string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+			return
+		}
+	}
+
+	participant, ok := findParticipant(w, receiptID, mux.Vars(r)["participantId"])
+	if !ok || !authorizeParticipant(w, r, participant) {
+		return
+	}
+
+	receipt, ok := findReceipt(w, receiptID, "Items", "Modifiers", "Participants.Claims", "Settlements")
+	if !ok {
+		return
+	}
+
+	settlement, ok := saveSettlement(w, receipt, participant.ID, func(s *models.Settlement, owed money.Money) {
+		now := time.Now()
+		s.ReportedPaidAt = &now
+		// A confirmed payment keeps the amount the payer confirmed
+		if s.Status != models.SettlementPaid {
+			s.Amount = owed
+		}
+		if input.Method != "" {
+			s.Method = input.Method
+		}
+		if input.Note != "" {
+			s.Note = input.Note
+		}
+		// Reporting payment again reopens a dispute for the payer to review
+		if s.Status == models.SettlementDisputed {
+			s.Status = models.SettlementPending
+		}
+	})
+	if !ok {
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, settlement)
+}
+
+// UpdateSettlementHandler lets the payer mark a participant's share as paid,
+// disputed, forgiven or back to pending
+func UpdateSettlementHandler(w http.ResponseWriter, r *http.Request) {
+	access := accessFromContext(r.Context())
+	if !access.Owner {
+		helpers.JSONErrorResponse(w, http.StatusForbidden, "Only the payer can update settlements")
+		return
+	}
+
+	var input struct {
+		Status string  `json:"status"`
+		Method *string `json:"method"`
+		Note   *string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if !settlementStatuses[input.Status] {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Status must be pending, paid, disputed or forgiven")
+		return
+	}
+
+	receipt, ok := findReceipt(w, access.ReceiptID, "Items", "Modifiers", "Participants.Claims", "Settlements")
+	if !ok {
+		return
+	}
+
+	settlement, ok := saveSettlement(w, receipt, mux.Vars(r)["participantId"], func(s *models.Settlement, owed money.Money) {
+		now := time.Now()
+		s.Amount = owed
+		s.Status = input.Status
+		s.ConfirmedAt = &now
+		if input.Method != nil {
+			s.Method = *input.Method
+		}
+		if input.Note != nil {
+			s.Note = *input.Note
+		}
+	})
+	if !ok {
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, settlement)
+}
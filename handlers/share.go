@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// receiptAccessKey is the context key for how a request reached a receipt
+type receiptAccessKey struct{}
+
+// receiptAccess records which receipt a request may act on, and whether it
+// came from the owner or a guest holding a share link
+type receiptAccess struct {
+	ReceiptID   string
+	Owner       bool
+	ShareLinkID string
+}
+
+// accessFromContext returns the receipt access granted by middleware
+func accessFromContext(ctx context.Context) receiptAccess {
+	access, _ := ctx.Value(receiptAccessKey{}).(receiptAccess)
+	return access
+}
+
+// hashShareToken returns the stored form of a share token
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReceiptOwnerMiddleware only lets the owner of the receipt in the {id} path
+// variable through. It must run after auth.JWTMiddleware.
+func ReceiptOwnerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserIDFromContext(r.Context())
+		if !ok {
+			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if _, err := uuid.Parse(id); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusNotFound, "Receipt not found")
+			return
+		}
+
+		var receipt models.Receipt
+		err := db.DB.Select("id").Where("id = ? AND user_id = ?", id, userID).First(&receipt).Error
+		if err != nil {
+			// Don't reveal whether receipts owned by others exist
+			if err == gorm.ErrRecordNotFound {
+				helpers.JSONErrorResponse(w, http.StatusNotFound, "Receipt not found")
+				return
+			}
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve receipt")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), receiptAccessKey{}, receiptAccess{ReceiptID: receipt.ID, Owner: true})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ShareLinkMiddleware resolves the {token} path variable to the receipt it
+// was minted for, rejecting revoked or expired links
+func ShareLinkMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var link models.ShareLink
+		err := db.DB.Where("token_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+			hashShareToken(mux.Vars(r)["token"]), time.Now()).First(&link).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				helpers.JSONErrorResponse(w, http.StatusNotFound, "Share link not found or expired")
+				return
+			}
+			helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve share link")
+			return
+		}
+
+		access := receiptAccess{ReceiptID: link.ReceiptID, ShareLinkID: link.ID}
+
+		// The owner opening their own link keeps owner rights
+		if userID, ok := auth.GetUserIDFromContext(r.Context()); ok {
+			var count int64
+			db.DB.Model(&models.Receipt{}).Where("id = ? AND user_id = ?", link.ReceiptID, userID).Count(&count)
+			access.Owner = count > 0
+		}
+
+		ctx := context.WithValue(r.Context(), receiptAccessKey{}, access)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CreateShareLinkHandler mints a new share link for a receipt. The token is
+// only returned here; just its hash is stored.
+func CreateShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	access := accessFromContext(r.Context())
+
+	var input struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Invalid input")
+			return
+		}
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	link := models.ShareLink{
+		ReceiptID: access.ReceiptID,
+		TokenHash: hashShareToken(token),
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := db.DB.Create(&link).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to create share link")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"id":         link.ID,
+		"token":      token,
+		"path":       "/shared/" + token,
+		"expires_at": link.ExpiresAt,
+		"created_at": link.CreatedAt,
+	})
+}
+
+// GetShareLinksHandler lists a receipt's share links, without their tokens
+func GetShareLinksHandler(w http.ResponseWriter, r *http.Request) {
+	access := accessFromContext(r.Context())
+
+	var links []models.ShareLink
+	if err := db.DB.Where("receipt_id = ?", access.ReceiptID).Order("created_at").Find(&links).Error; err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to fetch share links")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, links)
+}
+
+// RevokeShareLinkHandler permanently disables a share link
+func RevokeShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	access := accessFromContext(r.Context())
+
+	result := db.DB.Model(&models.ShareLink{}).
+		Where("id = ? AND receipt_id = ? AND revoked_at IS NULL", mux.Vars(r)["linkId"], access.ReceiptID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to revoke share link")
+		return
+	}
+	if result.RowsAffected == 0 {
+		helpers.JSONErrorResponse(w, http.StatusNotFound, "Share link not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
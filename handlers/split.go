@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/split"
+)
+
+// formatShare converts a share's minor-unit amounts for the response
+func formatShare(currency string, share split.Share, names map[string]string) map[string]interface{} {
+	lines := map[string]money.Money{}
+	for itemID, amount := range share.Lines {
+		lines[itemID] = money.New(amount, currency)
+	}
+	data := map[string]interface{}{
+		"person":      share.Person,
+		"items":       money.New(share.Items, currency),
+		"adjustments": money.New(share.Adjustments, currency),
+		"total":       money.New(share.Total, currency),
+		"lines":       lines,
+	}
+	if name, ok := names[share.Person]; ok {
+		data["name"] = name
+	}
+	return data
+}
+
+// formatSplit converts a split result for the response, naming shares that
+// belong to the receipt's participants and adding any currency conversions
+func formatSplit(receipt *models.Receipt, result *split.Result, settlements map[string]settlement) map[string]interface{} {
+	names := map[string]string{}
+	for _, p := range receipt.Participants {
+		names[p.ID] = p.Name
+	}
+
+	shares := make([]map[string]interface{}, 0, len(result.Shares))
+	for _, share := range result.Shares {
+		data := formatShare(result.Currency, share, names)
+		if s, ok := settlements[share.Person]; ok {
+			data["settlement"] = s.Amount
+			data["exchange_rate"] = map[string]interface{}{
+				"rate":   s.Rate.String(),
+				"as_of":  s.Rate.AsOf,
+				"source": s.Rate.Source,
+			}
+		}
+		shares = append(shares, data)
+	}
+	return map[string]interface{}{
+		"receipt_id":  receipt.ID,
+		"currency":    result.Currency,
+		"subtotal":    money.New(result.Subtotal, result.Currency),
+		"adjustments": money.New(result.Adjustments, result.Currency),
+		"total":       money.New(result.Total, result.Currency),
+		"shares":      shares,
+		"unassigned":  formatShare(result.Currency, result.Unassigned, names),
+	}
+}
+
+// GetReceiptSplitHandler calculates what each person owes on a receipt.
+// By default the split follows the claims participants have made. Ad-hoc
+// assignments can be passed instead as repeated query parameters of the form
+// assign=<itemId>:<person>; people assigned to the same item share it equally.
+// Shares are converted into each participant's settlement currency, or into
+// the currency query parameter when given. Only the payer's requests fix the
+// rates used on the receipt; guests see live rates until then.
+func GetReceiptSplitHandler(w http.ResponseWriter, r *http.Request) {
+	access := accessFromContext(r.Context())
+	id := access.ReceiptID
+
+	receipt, ok := findReceipt(w, id, "Items", "Modifiers", "Participants.Claims", "ExchangeRates")
+	if !ok {
+		return
+	}
+
+	result, ok := splitReceipt(w, r, receipt)
+	if !ok {
+		return
+	}
+
+	currency := r.URL.Query().Get("currency")
+	if currency != "" {
+		var err error
+		if currency, err = money.NormaliseCurrency(currency); err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Unknown currency")
+			return
+		}
+	}
+	settlements, err := settleShares(r.Context(), receipt, result, currency, access.Owner)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadGateway, "Failed to convert currency: "+err.Error())
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, formatSplit(receipt, result, settlements))
+}
+
+// splitReceipt splits a receipt by participant claims, or by the ad-hoc
+// assignments in the request's query, writing an error response and
+// returning false on failure
+func splitReceipt(w http.ResponseWriter, r *http.Request, receipt *models.Receipt) (*split.Result, bool) {
+	// Without explicit assignments, split by participant claims
+	if len(r.URL.Query()["assign"]) == 0 {
+		result, err := split.Claims(*receipt, receipt.Participants)
+		if err != nil {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+		return result, true
+	}
+
+	// Parse item assignments
+	itemIDs := map[string]bool{}
+	for _, item := range receipt.Items {
+		itemIDs[item.ID] = true
+	}
+	assignments := map[string][]string{}
+	for _, value := range r.URL.Query()["assign"] {
+		itemID, person, found := strings.Cut(value, ":")
+		if !found || person == "" {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Assignments must be in the form itemId:person")
+			return nil, false
+		}
+		if !itemIDs[itemID] {
+			helpers.JSONErrorResponse(w, http.StatusBadRequest, "Unknown item: "+itemID)
+			return nil, false
+		}
+		assignments[itemID] = append(assignments[itemID], person)
+	}
+
+	result, err := split.Receipt(*receipt, assignments)
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return result, true
+}
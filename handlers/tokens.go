@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"receipt-splitter-backend/auth"
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/helpers"
+	"receipt-splitter-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// errRefreshTokenReused is returned when a refresh token is presented twice
+var errRefreshTokenReused = &editError{http.StatusUnauthorized, "Refresh token has already been used"}
+
+// issueTokens creates an access token and a refresh token for a session,
+// returning them in the shape login and refresh respond with
+func issueTokens(tx *gorm.DB, userID, sessionID string) (map[string]interface{}, error) {
+	access, accessExpiresAt, err := auth.GenerateJWT(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	token := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  sessionID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL),
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":                    access,
+		"token_expires_at":         accessExpiresAt,
+		"refresh_token":            refresh,
+		"refresh_token_expires_at": token.ExpiresAt,
+	}, nil
+}
+
+// findRefreshToken looks up a refresh token presented by a client
+func findRefreshToken(tx *gorm.DB, token string) (*models.RefreshToken, error) {
+	var stored models.RefreshToken
+	if err := tx.Where("token_hash = ?", auth.HashRefreshToken(token)).First(&stored).Error; err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// RefreshTokenHandler swaps a refresh token for a new access token and a new
+// refresh token. Each refresh token works once; presenting one again revokes
+// every token from the same login.
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Refresh token is required")
+		return
+	}
+
+	var tokens map[string]interface{}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		stored, err := findRefreshToken(tx, input.RefreshToken)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return &editError{http.StatusUnauthorized, "Invalid refresh token"}
+			}
+			return err
+		}
+		if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+			return &editError{http.StatusUnauthorized, "Invalid refresh token"}
+		}
+
+		// The session is kept alive as long as its tokens are
+		now := time.Now()
+		result := tx.Model(&models.Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", stored.FamilyID, stored.UserID).
+			Updates(map[string]interface{}{
+				"last_seen_at": now,
+				"expires_at":   now.Add(auth.RefreshTokenTTL),
+				"ip_address":   clientIP(r),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &editError{http.StatusUnauthorized, "Invalid refresh token"}
+		}
+
+		// Marking the token used only succeeds once, even for concurrent requests
+		result = tx.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", stored.ID).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		tokens, err = issueTokens(tx, stored.UserID, stored.FamilyID)
+		return err
+	})
+	if err == errRefreshTokenReused {
+		// Someone else has used this token: revoke the whole login so
+		// neither the thief nor the user can carry on with it
+		if stored, err := findRefreshToken(db.DB, input.RefreshToken); err == nil {
+			if err := revokeSessions(db.DB, "id = ?", stored.FamilyID); err != nil {
+				helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to refresh token")
+				return
+			}
+		}
+		helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Refresh token has already been used")
+		return
+	}
+	if err != nil {
+		writeEditError(w, err, "Failed to refresh token")
+		return
+	}
+
+	helpers.JSONResponse(w, http.StatusOK, tokens)
+}
+
+// LogoutHandler ends the session a refresh token belongs to. With
+// "all": true it ends every session of the user, logging out all their
+// devices.
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+		All          bool   `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		helpers.JSONErrorResponse(w, http.StatusBadRequest, "Refresh token is required")
+		return
+	}
+
+	stored, err := findRefreshToken(db.DB, input.RefreshToken)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			helpers.JSONErrorResponse(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	if input.All {
+		err = revokeSessions(db.DB, "user_id = ?", stored.UserID)
+	} else {
+		err = revokeSessions(db.DB, "id = ?", stored.FamilyID)
+	}
+	if err != nil {
+		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newSession logs a user in on the device making the request, clearing out
+// their expired sessions while at it
+func newSession(r *http.Request, userID string) (map[string]interface{}, error) {
+	now := time.Now()
+	db.DB.Where("user_id = ? AND expires_at < ?", userID, now).Delete(&models.RefreshToken{})
+	db.DB.Where("user_id = ? AND expires_at < ?", userID, now).Delete(&models.Session{})
+
+	session := models.Session{
+		UserID:     userID,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(auth.RefreshTokenTTL),
+	}
+	var tokens map[string]interface{}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, err = issueTokens(tx, userID, session.ID)
+		return err
+	})
+	return tokens, err
+}
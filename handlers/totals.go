@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log"
+
+	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/split"
+
+	"gorm.io/gorm"
+)
+
+// refreshReceiptTotals recalculates the stored total and outstanding amount
+// of a receipt. It must be called after anything that changes them: items,
+// modifiers, claims, settlements or the receipt's group. The group ledger
+// is refreshed with them.
+func refreshReceiptTotals(tx *gorm.DB, receiptID string) error {
+	var receipt models.Receipt
+	err := tx.Preload("Items").Preload("Modifiers").Preload("Participants.Claims").Preload("Settlements").
+		First(&receipt, "id = ?", receiptID).Error
+	if err != nil {
+		return err
+	}
+
+	result, err := split.Claims(receipt, receipt.Participants)
+	if err != nil {
+		return err
+	}
+	balances, outstanding, err := receiptBalances(&receipt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.Receipt{}).Where("id = ?", receiptID).Updates(map[string]interface{}{
+		"total":       result.Total,
+		"outstanding": outstanding.Amount,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return refreshGroupLedger(tx, &receipt, balances)
+}
+
+// refreshTotalsAfter refreshes a receipt's totals outside a transaction,
+// logging rather than failing the request, which has already succeeded
+func refreshTotalsAfter(receiptID string) {
+	if err := refreshReceiptTotals(db.DB, receiptID); err != nil {
+		log.Printf("Failed to refresh totals of receipt %s: %v", receiptID, err)
+	}
+}
+
+// BackfillReceiptTotals works out the totals of receipts stored before
+// totals were kept
+func BackfillReceiptTotals() {
+	var ids []string
+	if err := db.DB.Model(&models.Receipt{}).Where("total IS NULL").Pluck("id", &ids).Error; err != nil {
+		log.Printf("Failed to find receipts without totals: %v", err)
+		return
+	}
+	for _, id := range ids {
+		refreshTotalsAfter(id)
+	}
+	if len(ids) > 0 {
+		log.Printf("Worked out totals for %d receipts", len(ids))
+	}
+}
@@ -19,7 +19,7 @@ func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 
 	// Query the user from the database
 	var user models.User
-	err := db.DB.Where("id = ?", userID).First(&user).Error
+	err := db.DB.Preload("PaymentMethods").Where("id = ?", userID).First(&user).Error
 	if err != nil {
 		helpers.JSONErrorResponse(w, http.StatusInternalServerError, "Failed to query user")
 		return
@@ -0,0 +1,239 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"receipt-splitter-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultTimeout     = 2 * time.Minute
+	retryBackoff       = 5 * time.Second
+	// pollInterval is how often queued jobs that didn't fit in the queue
+	// are looked for
+	pollInterval = 30 * time.Second
+)
+
+// ErrQueueFull is returned by Enqueue when the pool has too much work
+var ErrQueueFull = errors.New("parse job queue is full")
+
+// Processor runs a parse job and returns its result
+type Processor func(ctx context.Context, job *models.ParseJob) (interface{}, error)
+
+// permanentError marks a failure that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error so the job fails without being retried
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// Pool runs parse jobs on a fixed number of background workers. Jobs are
+// stored in the database, so any left unfinished by a restart are picked up
+// again when the pool starts.
+type Pool struct {
+	db          *gorm.DB
+	process     Processor
+	workers     int
+	queue       chan string
+	webhooks    *WebhookSender
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+// NewPool creates a pool of workers that run jobs with process
+func NewPool(db *gorm.DB, process Processor, workers int, webhooks *WebhookSender) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		db:          db,
+		process:     process,
+		workers:     workers,
+		queue:       make(chan string, 100),
+		webhooks:    webhooks,
+		MaxAttempts: defaultMaxAttempts,
+		Timeout:     defaultTimeout,
+	}
+}
+
+// Start launches the workers and requeues unfinished jobs. Jobs left running
+// for longer than a job may take were interrupted by a restart and are
+// queued again. Queued jobs that didn't fit in the queue are picked up as
+// it empties. Workers stop when ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		go p.work(ctx)
+	}
+
+	err := p.db.Model(&models.ParseJob{}).
+		Where("status = ? AND updated_at < ?", StatusRunning, time.Now().Add(-2*p.Timeout)).
+		Update("status", StatusQueued).Error
+	if err != nil {
+		log.Printf("Failed to requeue interrupted parse jobs: %v", err)
+	}
+
+	p.requeue(time.Now())
+	go p.poll(ctx)
+}
+
+// poll requeues stored jobs whenever the queue has drained
+func (p *Pool) poll(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if len(p.queue) == 0 {
+				p.requeue(time.Now().Add(-pollInterval))
+			}
+		}
+	}
+}
+
+// requeue enqueues queued jobs last touched before cutoff, oldest first,
+// until the queue is full. Jobs queued more recently are already in the
+// queue or waiting to be retried.
+func (p *Pool) requeue(cutoff time.Time) {
+	var pending []models.ParseJob
+	err := p.db.Select("id").Where("status = ? AND updated_at < ?", StatusQueued, cutoff).
+		Order("created_at").Limit(cap(p.queue)).Find(&pending).Error
+	if err != nil {
+		log.Printf("Failed to requeue parse jobs: %v", err)
+		return
+	}
+	for _, job := range pending {
+		if p.Enqueue(job.ID) != nil {
+			return
+		}
+	}
+}
+
+// Enqueue schedules a stored job to run, or returns ErrQueueFull rather than
+// block. A queued job that can't be enqueued stays in the database and is
+// picked up once the queue drains.
+func (p *Pool) Enqueue(id string) error {
+	select {
+	case p.queue <- id:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-p.queue:
+			p.run(ctx, id)
+		}
+	}
+}
+
+// run makes one attempt at a job, scheduling a retry or finishing it
+func (p *Pool) run(ctx context.Context, id string) {
+	// Claim the job, so a job enqueued twice, or by another instance, only
+	// runs once
+	claim := p.db.Model(&models.ParseJob{}).Where("id = ? AND status = ?", id, StatusQueued).
+		Updates(map[string]interface{}{"status": StatusRunning, "attempts": gorm.Expr("attempts + 1")})
+	if claim.Error != nil {
+		log.Printf("Failed to claim parse job %s: %v", id, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		return
+	}
+
+	var job models.ParseJob
+	if err := p.db.First(&job, "id = ?", id).Error; err != nil {
+		log.Printf("Failed to load parse job %s: %v", id, err)
+		return
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	result, err := p.process(jobCtx, &job)
+	cancel()
+
+	if err != nil {
+		var permanent *permanentError
+		if !errors.As(err, &permanent) && job.Attempts < p.MaxAttempts && ctx.Err() == nil {
+			log.Printf("Parse job %s attempt %d failed, retrying: %v", id, job.Attempts, err)
+			if err := p.db.Model(&job).Updates(map[string]interface{}{"status": StatusQueued, "error": err.Error()}).Error; err != nil {
+				log.Printf("Failed to requeue parse job %s: %v", id, err)
+				return
+			}
+			time.AfterFunc(retryBackoff*time.Duration(1<<(job.Attempts-1)), func() {
+				if err := p.Enqueue(id); err != nil {
+					log.Printf("Parse job %s left for the next poll: %v", id, err)
+				}
+			})
+			return
+		}
+		p.finish(&job, nil, err)
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		p.finish(&job, nil, err)
+		return
+	}
+	p.finish(&job, data, nil)
+}
+
+// finish records a job's outcome, drops its files and sends its webhook
+func (p *Pool) finish(job *models.ParseJob, result json.RawMessage, jobErr error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       StatusSucceeded,
+		"result":       result,
+		"error":        "",
+		"completed_at": now,
+	}
+	if jobErr != nil {
+		updates["status"] = StatusFailed
+		updates["error"] = jobErr.Error()
+		delete(updates, "result")
+	}
+	if err := p.db.Model(job).Updates(updates).Error; err != nil {
+		log.Printf("Failed to save parse job %s: %v", job.ID, err)
+		return
+	}
+	job.Status = updates["status"].(string)
+	job.Error = updates["error"].(string)
+	job.Result = result
+	job.CompletedAt = &now
+
+	// The uploaded files are only needed to run the job
+	if err := p.db.Where("parse_job_id = ?", job.ID).Delete(&models.ParseJobPage{}).Error; err != nil {
+		log.Printf("Failed to delete files of parse job %s: %v", job.ID, err)
+	}
+
+	if job.WebhookURL != "" && p.webhooks != nil {
+		go p.webhooks.Send(context.Background(), job.WebhookURL, job)
+	}
+}
@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"receipt-splitter-backend/models"
+)
+
+const webhookAttempts = 3
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook URL must be an absolute http or https URL")
+	ErrWebhookAddress    = errors.New("webhook URL must point to a public address")
+)
+
+// WebhookSender delivers finished jobs to client callback URLs. When a
+// secret is set each request carries an X-Signature header holding the
+// hex HMAC-SHA256 of the body, so receivers can check it came from us.
+type WebhookSender struct {
+	client *http.Client
+	secret []byte
+}
+
+// NewWebhookSender creates a sender that signs payloads with secret
+func NewWebhookSender(secret string) *WebhookSender {
+	// Check the address actually dialled too, so a host that resolved to a
+	// public address when the URL was submitted can't later point inside
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: checkDialAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &WebhookSender{
+		client: &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		secret: []byte(secret),
+	}
+}
+
+// ValidateWebhookURL checks a callback URL is usable and only reaches public
+// addresses, so webhooks can't be used to call services inside our network
+func ValidateWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrInvalidWebhookURL
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// nonPublicPrefixes are special-purpose ranges that aren't reachable on the
+// public internet but that net.IP has no check for
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "This network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard-only
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+}
+
+// publicIP reports whether an address is on the public internet rather than
+// loopback, private, link-local (including cloud metadata endpoints),
+// unspecified or another special-purpose range
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDialAddress refuses connections to non-public addresses
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return ErrWebhookAddress
+	}
+	return nil
+}
+
+// Send posts a job to a webhook URL, retrying failed deliveries with backoff
+func (s *WebhookSender) Send(ctx context.Context, target string, job *models.ParseJob) {
+	body, err := json.Marshal(map[string]interface{}{
+		"event": "parse_job." + job.Status,
+		"job":   job,
+	})
+	if err != nil {
+		log.Printf("Failed to encode webhook for parse job %s: %v", job.ID, err)
+		return
+	}
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = s.post(ctx, target, body); err == nil {
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+	}
+	log.Printf("Failed to deliver webhook for parse job %s: %v", job.ID, err)
+}
+
+func (s *WebhookSender) post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures a mailer
+type Config struct {
+	// Driver is "smtp" (the default) or "log", which only logs mail and is
+	// meant for local development
+	Driver string
+	// Host is the SMTP server, e.g. "smtp.sendgrid.net" or "localhost" for
+	// a local sink such as MailHog
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address, e.g. "Receipt Splitter <no-reply@example.com>"
+	From string
+}
+
+// NewMailer creates the mailer described by cfg
+func NewMailer(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "log":
+		return LogMailer{}, nil
+	case "", "smtp":
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+	if cfg.Host == "" {
+		return nil, errors.New("an SMTP host is required to send mail")
+	}
+	if cfg.From == "" {
+		return nil, errors.New("a from address is required to send mail")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	return &SMTPMailer{
+		Addr:     cfg.Host + ":" + strconv.Itoa(cfg.Port),
+		Host:     cfg.Host,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+	}, nil
+}
+
+// LogMailer writes emails to the log instead of sending them, for local
+// development without an SMTP server
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultSendTimeout bounds a send whose context has no deadline
+const defaultSendTimeout = time.Minute
+
+// SMTPMailer sends email through an SMTP server, upgrading to TLS when the
+// server offers it. Credentials are only sent over TLS or to localhost.
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return m.send(ctx, auth, from.Address, to.Address, m.message(from, to, msg))
+}
+
+// send delivers a message like smtp.SendMail, but over a connection that
+// is closed once ctx is done and has its deadline, so a hung server can't
+// hold the connection open
+func (m *SMTPMailer) send(ctx context.Context, auth smtp.Auth, from, to string, body []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSendTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return ctxErr(ctx, err)
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return ctxErr(ctx, err)
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return ctxErr(ctx, err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return ctxErr(ctx, err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return ctxErr(ctx, err)
+	}
+	if err := w.Close(); err != nil {
+		return ctxErr(ctx, err)
+	}
+	return ctxErr(ctx, c.Quit())
+}
+
+// ctxErr reports a cancelled or expired context in place of the network
+// error it caused
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// message builds the raw email
+func (m *SMTPMailer) message(from, to *mail.Address, msg Message) []byte {
+	var b bytes.Buffer
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + uuid.NewString() + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Text, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
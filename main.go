@@ -1,46 +1,228 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"receipt-splitter-backend/auth"
 	"receipt-splitter-backend/db"
+	"receipt-splitter-backend/fx"
 	"receipt-splitter-backend/handlers"
+	"receipt-splitter-backend/jobs"
+	"receipt-splitter-backend/mail"
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/ocr"
+	"receipt-splitter-backend/parsing"
+	"receipt-splitter-backend/storage"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
 func main() {
+	auth.InitSessions(handlers.SessionActive)
 	db.InitDB()
-	handlers.InitOpenAIClient(os.Getenv("OPENAPI_API_KEY"))
+
+	rates, err := fx.NewProvider(os.Getenv("FX_PROVIDER"), os.Getenv("FX_RATES_FILE"), db.DB)
+	if err != nil {
+		log.Fatalf("Failed to load exchange rates: %v", err)
+	}
+	handlers.InitExchangeRates(rates)
+	handlers.BackfillReceiptTotals()
+
+	textReader, err := ocr.NewProvider(ocr.Config{
+		Provider:           os.Getenv("OCR_PROVIDER"),
+		GoogleAPIKey:       os.Getenv("GOOGLE_API_KEY"),
+		TesseractPath:      os.Getenv("TESSERACT_PATH"),
+		TesseractLanguages: os.Getenv("TESSERACT_LANGUAGES"),
+	})
+	if err != nil {
+		// Everything but receipt parsing still works without OCR
+		log.Printf("OCR unavailable, receipt parsing is disabled: %v", err)
+	} else {
+		handlers.InitOCR(textReader)
+	}
+
+	// e.g. LLM_REPROMPT_THRESHOLD=1 re-prompts when the lines are over 1% out
+	var threshold money.Percent
+	if v := os.Getenv("LLM_REPROMPT_THRESHOLD"); v != "" {
+		threshold, err = money.ParsePercent(v)
+		if err != nil {
+			log.Fatalf("Invalid LLM_REPROMPT_THRESHOLD: %v", err)
+		}
+	}
+	structurer, err := parsing.NewStructurer(parsing.Config{
+		Provider:          os.Getenv("LLM_PROVIDER"),
+		APIKey:            os.Getenv("OPENAPI_API_KEY"),
+		BaseURL:           os.Getenv("LLM_BASE_URL"),
+		Model:             os.Getenv("LLM_MODEL"),
+		RepromptThreshold: threshold,
+	})
+	if err != nil {
+		log.Printf("Receipt structurer unavailable, receipt parsing is disabled: %v", err)
+	} else {
+		handlers.InitStructurer(structurer)
+	}
+
+	store, err := storage.NewStore(storage.Config{
+		Driver:          os.Getenv("STORAGE_DRIVER"),
+		Path:            os.Getenv("STORAGE_PATH"),
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Region:          os.Getenv("S3_REGION"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Insecure:        os.Getenv("S3_INSECURE") == "true",
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up storage: %v", err)
+	}
+	handlers.InitStorage(store)
+	handlers.StartImageCleanup(context.Background(), time.Hour)
+
+	// Proxies in front of the server, e.g. "10.0.0.0/8,127.0.0.1", whose
+	// X-Forwarded-For headers say where requests came from
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := handlers.InitTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			log.Fatalf("Failed to set trusted proxies: %v", err)
+		}
+	}
+
+	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	mailer, err := mail.NewMailer(mail.Config{
+		Driver:   os.Getenv("MAIL_DRIVER"),
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     smtpPort,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	})
+	if err != nil {
+		log.Printf("Email disabled: %v", err)
+	} else {
+		appURL := os.Getenv("APP_URL")
+		if appURL == "" {
+			// The SvelteKit dev server
+			appURL = "http://localhost:5173"
+		}
+		handlers.InitMailer(mailer, appURL)
+	}
+
+	workers, _ := strconv.Atoi(os.Getenv("PARSE_WORKERS"))
+	if workers == 0 {
+		workers = 4
+	}
+	webhooks := jobs.NewWebhookSender(os.Getenv("WEBHOOK_SECRET"))
+	parsePool := jobs.NewPool(db.DB, handlers.ProcessParseJob, workers, webhooks)
+	parsePool.Start(context.Background())
+	handlers.InitParseJobs(parsePool)
 
 	r := mux.NewRouter()
 
 	// Auth routes
 	r.HandleFunc("/register", handlers.RegisterHandler).Methods("POST")
 	r.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	r.HandleFunc("/token/refresh", handlers.RefreshTokenHandler).Methods("POST")
+	r.HandleFunc("/logout", handlers.LogoutHandler).Methods("POST")
+	r.HandleFunc("/verify-email", handlers.VerifyEmailHandler).Methods("POST")
+	r.Handle("/verify-email/resend", auth.JWTMiddleware(http.HandlerFunc(handlers.ResendVerificationHandler))).Methods("POST")
+	r.HandleFunc("/password/forgot", handlers.ForgotPasswordHandler).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPasswordHandler).Methods("POST")
 
 	// Auth routes
 	r.HandleFunc("/health", handlers.HealthCheckHandler).Methods("GET")
 
 	// User routes
 	r.Handle("/me", auth.JWTMiddleware(http.HandlerFunc(handlers.GetCurrentUser))).Methods("GET")
+	r.Handle("/me/sessions", auth.JWTMiddleware(http.HandlerFunc(handlers.GetSessionsHandler))).Methods("GET")
+	r.Handle("/me/sessions/{sessionId}", auth.JWTMiddleware(http.HandlerFunc(handlers.DeleteSessionHandler))).Methods("DELETE")
+	r.Handle("/me/payment-methods", auth.JWTMiddleware(http.HandlerFunc(handlers.GetPaymentMethodsHandler))).Methods("GET")
+	r.Handle("/me/payment-methods", auth.JWTMiddleware(http.HandlerFunc(handlers.CreatePaymentMethodHandler))).Methods("POST")
+	r.Handle("/me/payment-methods/{methodId}", auth.JWTMiddleware(http.HandlerFunc(handlers.DeletePaymentMethodHandler))).Methods("DELETE")
 
 	// Receipt routes (protected)
 	r.Handle("/receipts", auth.JWTMiddleware(http.HandlerFunc(handlers.CreateReceiptHandler))).Methods("POST")
 	r.Handle("/receipts/parse", auth.JWTMiddleware(http.HandlerFunc(handlers.ParseReceiptHandler))).Methods("POST")
 	r.Handle("/receipts", auth.JWTMiddleware(http.HandlerFunc(handlers.GetAllReceiptsHandler))).Methods("GET")
-	r.Handle("/receipts/{id}", http.HandlerFunc(handlers.GetReceiptByIDHandler)).Methods("GET")
+	r.Handle("/receipts/search", auth.JWTMiddleware(http.HandlerFunc(handlers.SearchReceiptsHandler))).Methods("GET")
+	r.Handle("/parse-jobs/{id}", auth.JWTMiddleware(http.HandlerFunc(handlers.GetParseJobHandler))).Methods("GET")
+
+	// Group routes
+	r.Handle("/groups", auth.JWTMiddleware(http.HandlerFunc(handlers.CreateGroupHandler))).Methods("POST")
+	r.Handle("/groups", auth.JWTMiddleware(http.HandlerFunc(handlers.GetGroupsHandler))).Methods("GET")
+	r.Handle("/me/group-invites", auth.JWTMiddleware(http.HandlerFunc(handlers.GetMyGroupInvitesHandler))).Methods("GET")
+	r.Handle("/me/group-invites/{inviteId}/accept", auth.JWTMiddleware(http.HandlerFunc(handlers.AcceptGroupInviteHandler))).Methods("POST")
+	r.Handle("/me/group-invites/{inviteId}", auth.JWTMiddleware(http.HandlerFunc(handlers.DeclineGroupInviteHandler))).Methods("DELETE")
+	group := r.PathPrefix("/groups/{id}").Subrouter()
+	group.Use(auth.JWTMiddleware, handlers.GroupMemberMiddleware)
+	group.HandleFunc("", handlers.GetGroupHandler).Methods("GET")
+	group.HandleFunc("/invites", handlers.InviteGroupMemberHandler).Methods("POST")
+	group.HandleFunc("/invites", handlers.GetGroupInvitesHandler).Methods("GET")
+	group.HandleFunc("/invites/{inviteId}", handlers.CancelGroupInviteHandler).Methods("DELETE")
+	group.HandleFunc("/members/{userId}", handlers.RemoveGroupMemberHandler).Methods("DELETE")
+	group.HandleFunc("/balances", handlers.GetGroupBalancesHandler).Methods("GET")
+	group.HandleFunc("/settlement-plan", handlers.GetGroupSettlementPlanHandler).Methods("GET")
+	group.HandleFunc("/payments", handlers.RecordGroupPaymentHandler).Methods("POST")
+	group.HandleFunc("/payments", handlers.GetGroupPaymentsHandler).Methods("GET")
+	group.HandleFunc("/payments/{paymentId}/confirm", handlers.ConfirmGroupPaymentHandler).Methods("POST")
+	group.HandleFunc("/payments/{paymentId}", handlers.DeleteGroupPaymentHandler).Methods("DELETE")
+
+	// Single receipt routes, reachable by the owner through the receipt ID
+	// and by guests through a share link
+	receiptRoutes := func(s *mux.Router) {
+		s.HandleFunc("", handlers.GetReceiptByIDHandler).Methods("GET")
+		s.HandleFunc("/split", handlers.GetReceiptSplitHandler).Methods("GET")
+		s.HandleFunc("/image", handlers.GetReceiptImageHandler).Methods("GET")
+		s.HandleFunc("/participants", handlers.JoinReceiptHandler).Methods("POST")
+		s.HandleFunc("/participants", handlers.GetParticipantsHandler).Methods("GET")
+		s.HandleFunc("/participants/{participantId}", handlers.UpdateParticipantHandler).Methods("PATCH")
+		s.HandleFunc("/participants/{participantId}/claims", handlers.ClaimItemHandler).Methods("POST")
+		s.HandleFunc("/participants/{participantId}/claims/{claimId}", handlers.UnclaimItemHandler).Methods("DELETE")
+		s.HandleFunc("/unclaimed", handlers.GetUnclaimedHandler).Methods("GET")
+		s.HandleFunc("/payment-links", handlers.GetPaymentLinksHandler).Methods("GET")
+		s.HandleFunc("/participants/{participantId}/payment-qr", handlers.GetPaymentQRHandler).Methods("GET")
+		s.HandleFunc("/settlements", handlers.GetSettlementsHandler).Methods("GET")
+		s.HandleFunc("/participants/{participantId}/paid", handlers.ReportPaidHandler).Methods("POST")
+		s.HandleFunc("/participants/{participantId}/settlement", handlers.UpdateSettlementHandler).Methods("PUT")
+	}
+
+	owner := r.PathPrefix("/receipts/{id}").Subrouter()
+	owner.Use(auth.JWTMiddleware, handlers.ReceiptOwnerMiddleware)
+	receiptRoutes(owner)
+
+	// Editing routes (owner only)
+	owner.HandleFunc("", handlers.ReplaceReceiptHandler).Methods("PUT")
+	owner.HandleFunc("", handlers.UpdateReceiptHandler).Methods("PATCH")
+	owner.HandleFunc("", handlers.DeleteReceiptHandler).Methods("DELETE")
+	owner.HandleFunc("/items", handlers.AddItemHandler).Methods("POST")
+	owner.HandleFunc("/items/{itemId}", handlers.UpdateItemHandler).Methods("PATCH")
+	owner.HandleFunc("/items/{itemId}", handlers.DeleteItemHandler).Methods("DELETE")
+	owner.HandleFunc("/modifiers", handlers.AddModifierHandler).Methods("POST")
+	owner.HandleFunc("/modifiers/{modifierId}", handlers.UpdateModifierHandler).Methods("PATCH")
+	owner.HandleFunc("/modifiers/{modifierId}", handlers.DeleteModifierHandler).Methods("DELETE")
+
+	// Share link routes (owner only)
+	owner.HandleFunc("/share-links", handlers.CreateShareLinkHandler).Methods("POST")
+	owner.HandleFunc("/share-links", handlers.GetShareLinksHandler).Methods("GET")
+	owner.HandleFunc("/share-links/{linkId}", handlers.RevokeShareLinkHandler).Methods("DELETE")
+
+	// Guest routes (signed-in guests are linked to their account when joining)
+	shared := r.PathPrefix("/shared/{token}").Subrouter()
+	shared.Use(auth.OptionalJWTMiddleware, handlers.ShareLinkMiddleware)
+	receiptRoutes(shared)
 
 	// CORS middleware
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-Participant-Secret"},
+		ExposedHeaders: []string{"X-Next-Cursor", "X-Image-Count"},
 	}).Handler(r)
 
 	host := os.Getenv("APP_PORT")
@@ -1,46 +1,341 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"receipt-splitter-backend/money"
+)
 
 // Receipt represents a receipt with associated items and modifiers
 type Receipt struct {
-	ID        string        `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	Name      string        `gorm:"not null" json:"name"`
-	MonzoID   string        `gorm:"not null" json:"monzo_id"`
-	Reason    string        `gorm:"type:text" json:"reason"`
-	UserID    string        `gorm:"not null" json:"-"`
-	User      User          `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user"`
-	Items     []ReceiptItem `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"items,omitempty"`
-	Modifiers []Modifier    `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"modifiers,omitempty"`
-	CreatedAt time.Time     `gorm:"autoCreateTime" json:"created_at"`
+	ID           string        `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name         string        `gorm:"not null" json:"name"`
+	MonzoID      string        `gorm:"not null" json:"monzo_id"`
+	Reason       string        `gorm:"type:text" json:"reason"`
+	Currency     string        `gorm:"type:char(3);not null;default:'GBP'" json:"currency"`
+	UserID       string        `gorm:"not null" json:"-"`
+	User         User          `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user"`
+	Items        []ReceiptItem `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"items,omitempty"`
+	Modifiers    []Modifier    `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"modifiers,omitempty"`
+	Participants []Participant `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"participants,omitempty"`
+	Settlements  []Settlement  `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"settlements,omitempty"`
+	// ExchangeRates records the rates used to convert this receipt into
+	// participants' settlement currencies, so later splits stay consistent
+	ExchangeRates []ReceiptExchangeRate `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"exchange_rates,omitempty"`
+	Images        []ReceiptImage        `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"images,omitempty"`
+	// GroupID is the group whose shared balances the receipt counts towards
+	GroupID *string `gorm:"type:uuid;index" json:"group_id,omitempty"`
+	Group   *Group  `gorm:"foreignKey:GroupID;constraint:OnDelete:SET NULL" json:"-"`
+	// Total and Outstanding are kept up to date as the receipt changes so
+	// receipts can be filtered and sorted by them. Both are minor units of
+	// the receipt's currency, and nil until first worked out.
+	Total       *int64    `gorm:"index" json:"-"`
+	Outstanding *int64    `json:"-"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
 // ReceiptItem represents an item on a receipt
 type ReceiptItem struct {
-	ID        string  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	ReceiptID string  `gorm:"not null" json:"-"`
-	Item      string  `gorm:"not null" json:"item"`
-	Price     float64 `gorm:"not null" json:"price"`
-	Qty       int     `gorm:"not null" json:"qty"`
+	ID        string      `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID string      `gorm:"not null" json:"-"`
+	Item      string      `gorm:"not null" json:"item"`
+	Price     money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	Qty       int         `gorm:"not null" json:"qty"`
 }
 
 // Modifier represents a discount or adjustment applied to a receipt
 type Modifier struct {
-	ID         string   `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	ReceiptID  string   `gorm:"not null" json:"-"`
-	Type       string   `gorm:"not null" json:"type"`
-	Value      float64  `gorm:"not null" json:"value"`
-	Percentage *float64 `gorm:"type:numeric" json:"percentage,omitempty"`
-	Include    bool     `gorm:"not null" json:"include"`
+	ID         string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID  string         `gorm:"not null" json:"-"`
+	Type       string         `gorm:"not null" json:"type"`
+	Value      money.Money    `gorm:"embedded;embeddedPrefix:value_" json:"value"`
+	Percentage *money.Percent `gorm:"type:numeric" json:"percentage,omitempty"`
+	Include    bool           `gorm:"not null" json:"include"`
+}
+
+// Participant represents a person splitting a receipt, who may be a guest
+type Participant struct {
+	ID        string  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID string  `gorm:"not null;index" json:"receipt_id"`
+	UserID    *string `gorm:"type:uuid" json:"user_id,omitempty"`
+	Name      string  `gorm:"not null" json:"name"`
+	// PaymentMethod is the payment method type the participant prefers
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// SettlementCurrency is the currency the participant pays in, if
+	// different from the receipt's
+	SettlementCurrency string `gorm:"type:char(3)" json:"settlement_currency,omitempty"`
+	// SecretHash is the hash of the secret a guest was given on joining,
+	// which they need to act as this participant through a share link
+	SecretHash string      `json:"-"`
+	Claims     []ItemClaim `gorm:"foreignKey:ParticipantID;constraint:OnDelete:CASCADE" json:"claims,omitempty"`
+	CreatedAt  time.Time   `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ItemClaim records a participant taking some of a receipt item. The claimed
+// quantity is Units / Ways, so two people sharing one bottle each claim
+// 1 unit 2 ways, and someone who had two of three pints claims 2 units 1 way.
+type ItemClaim struct {
+	ID            string       `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ParticipantID string       `gorm:"not null;index" json:"participant_id"`
+	ReceiptItemID string       `gorm:"not null;index" json:"item_id"`
+	ReceiptItem   *ReceiptItem `gorm:"foreignKey:ReceiptItemID;constraint:OnDelete:CASCADE" json:"-"`
+	Units         int          `gorm:"not null;default:1" json:"units"`
+	Ways          int          `gorm:"not null;default:1" json:"ways"`
+	CreatedAt     time.Time    `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Settlement statuses
+const (
+	SettlementPending  = "pending"
+	SettlementPaid     = "paid"
+	SettlementDisputed = "disputed"
+	SettlementForgiven = "forgiven"
+)
+
+// Settlement tracks whether a participant has paid their share of a receipt.
+// Amount is what they owed when the settlement was last updated.
+type Settlement struct {
+	ID            string       `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID     string       `gorm:"not null;uniqueIndex:idx_settlement_participant" json:"receipt_id"`
+	ParticipantID string       `gorm:"not null;uniqueIndex:idx_settlement_participant" json:"participant_id"`
+	Participant   *Participant `gorm:"foreignKey:ParticipantID;constraint:OnDelete:CASCADE" json:"-"`
+	Amount        money.Money  `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Method        string       `json:"method,omitempty"`
+	Status        string       `gorm:"not null;default:pending" json:"status"`
+	Note          string       `gorm:"type:text" json:"note,omitempty"`
+	// ReportedPaidAt is when the participant said they had paid
+	ReportedPaidAt *time.Time `json:"reported_paid_at,omitempty"`
+	// ConfirmedAt is when the payer last set the status
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// PaymentMethod is a way a user can be paid. Handle holds the username,
+// IBAN or UPI address depending on Type.
+type PaymentMethod struct {
+	ID          string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID      string    `gorm:"not null;index" json:"-"`
+	Type        string    `gorm:"not null" json:"type"`
+	Handle      string    `gorm:"not null" json:"handle"`
+	AccountName string    `json:"account_name,omitempty"`
+	BIC         string    `json:"bic,omitempty"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ShareLink grants guests access to a receipt. Only a hash of the token is
+// stored, so links can't be recovered from the database.
+type ShareLink struct {
+	ID        string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID string     `gorm:"not null;index" json:"receipt_id"`
+	Receipt   *Receipt   `gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE" json:"-"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ExchangeRate is a published rate between two currencies
+type ExchangeRate struct {
+	ID           string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	FromCurrency string    `gorm:"type:char(3);not null;index:idx_exchange_rate_pair" json:"from"`
+	ToCurrency   string    `gorm:"type:char(3);not null;index:idx_exchange_rate_pair" json:"to"`
+	Rate         string    `gorm:"type:numeric;not null" json:"rate"`
+	AsOf         time.Time `gorm:"not null;index" json:"as_of"`
+}
+
+// ReceiptExchangeRate is the rate used to convert a receipt into another currency
+type ReceiptExchangeRate struct {
+	ID           string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID    string    `gorm:"not null;uniqueIndex:idx_receipt_rate_pair" json:"-"`
+	FromCurrency string    `gorm:"type:char(3);not null;uniqueIndex:idx_receipt_rate_pair" json:"from"`
+	ToCurrency   string    `gorm:"type:char(3);not null;uniqueIndex:idx_receipt_rate_pair" json:"to"`
+	Rate         string    `gorm:"type:numeric;not null" json:"rate"`
+	AsOf         time.Time `gorm:"not null" json:"as_of"`
+	Source       string    `gorm:"not null" json:"source"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Group is a set of users who split bills together often, such as
+// flatmates. Debts from the group's receipts are pooled into one balance
+// between each pair of members.
+type Group struct {
+	ID        string        `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name      string        `gorm:"not null" json:"name"`
+	OwnerID   string        `gorm:"type:uuid;not null" json:"owner_id"`
+	Owner     *User         `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE" json:"-"`
+	Members   []GroupMember `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE" json:"members,omitempty"`
+	CreatedAt time.Time     `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// GroupMember is a user belonging to a group
+type GroupMember struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	GroupID   string    `gorm:"not null;uniqueIndex:idx_group_member" json:"-"`
+	UserID    string    `gorm:"type:uuid;not null;uniqueIndex:idx_group_member" json:"user_id"`
+	User      *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"joined_at"`
+}
+
+// GroupInvite asks whoever owns an email address to join a group. The
+// invitee becomes a member only once they accept.
+type GroupInvite struct {
+	ID          string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	GroupID     string    `gorm:"not null;uniqueIndex:idx_group_invite" json:"group_id"`
+	Group       *Group    `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE" json:"-"`
+	Email       string    `gorm:"not null;uniqueIndex:idx_group_invite;index" json:"email"`
+	InvitedByID string    `gorm:"type:uuid;not null" json:"invited_by"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// GroupLedgerEntry is what one group member owes another for a group
+// receipt. Entries are rewritten whenever the receipt's balances change, so
+// summing them with the group's payments gives its running balances.
+type GroupLedgerEntry struct {
+	ID      string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	GroupID string `gorm:"not null;index" json:"group_id"`
+	Group   *Group `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE" json:"-"`
+	// ReceiptID is nil once the receipt is deleted; its debts stay in the
+	// group, as members may already have paid them back
+	ReceiptID  *string     `gorm:"type:uuid;index" json:"receipt_id"`
+	Receipt    *Receipt    `gorm:"foreignKey:ReceiptID;constraint:OnDelete:SET NULL" json:"-"`
+	DebtorID   string      `gorm:"type:uuid;not null" json:"debtor_id"`
+	CreditorID string      `gorm:"type:uuid;not null" json:"creditor_id"`
+	Amount     money.Money `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	// Settled is how much of Amount has been marked paid on the receipt, in
+	// the same currency
+	Settled   int64     `gorm:"not null;default:0" json:"settled"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// GroupPayment records one group member paying another back outside the
+// app, e.g. following the settlement plan. Once the payee confirms it, a
+// payment is netted against the ledger, so paying a debt clears it from the
+// group's balances.
+type GroupPayment struct {
+	ID           string      `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	GroupID      string      `gorm:"not null;index" json:"group_id"`
+	Group        *Group      `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE" json:"-"`
+	FromID       string      `gorm:"type:uuid;not null" json:"from"`
+	ToID         string      `gorm:"type:uuid;not null" json:"to"`
+	Amount       money.Money `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Method       string      `json:"method,omitempty"`
+	Note         string      `json:"note,omitempty"`
+	RecordedByID string      `gorm:"type:uuid;not null" json:"recorded_by"`
+	// ConfirmedAt is when the payee confirmed receiving the payment, nil
+	// while it is pending
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
 }
 
 // User represents a system user
 type User struct {
-	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	Name      string    `gorm:"not null" json:"name"`
-	Email     string    `gorm:"unique;not null" json:"email"`
-	Password  string    `gorm:"not null" json:"password"`
-	MonzoID   string    `gorm:"not null" json:"monzo_id"`
-	Receipts  []Receipt `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"receipts,omitempty"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	ID       string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name     string `gorm:"not null" json:"name"`
+	Email    string `gorm:"unique;not null" json:"email"`
+	Password string `gorm:"not null" json:"-"`
+	// EmailVerifiedAt is when the user proved they own their email address
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// MonzoID is kept for older clients; payment details now live in PaymentMethods
+	MonzoID        string          `gorm:"not null" json:"monzo_id"`
+	PaymentMethods []PaymentMethod `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"payment_methods,omitempty"`
+	Receipts       []Receipt       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"receipts,omitempty"`
+	CreatedAt      time.Time       `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Email token purposes
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// EmailToken is a single-use link emailed to a user, to verify their address
+// or reset their password. The link holds a signed token naming this record,
+// which is marked used once the link has been followed.
+type EmailToken struct {
+	ID        string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID    string     `gorm:"type:uuid;not null;index" json:"-"`
+	User      *User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Purpose   string     `gorm:"not null" json:"purpose"`
+	Email     string     `gorm:"not null" json:"email"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Session is one login on one device. Its ID is the jti claim of every
+// access token issued for it, so revoking it logs the device out at once.
+type Session struct {
+	ID         string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID     string     `gorm:"type:uuid;not null;index" json:"-"`
+	User       *User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RefreshToken lets a client get new access tokens without logging in
+// again. Tokens are rotated on every use; each login starts a family of
+// tokens, and the whole family is revoked if a used token is presented
+// again, as that means it was stolen. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID     string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID string `gorm:"type:uuid;not null;index" json:"-"`
+	User   *User  `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	// FamilyID is the ID of the Session the token belongs to
+	FamilyID  string     `gorm:"type:uuid;not null;index" json:"family_id"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ParseJob is a receipt image waiting to be, or already, parsed in the
+// background
+type ParseJob struct {
+	ID     string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID string `gorm:"not null;index" json:"-"`
+	User   *User  `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Status string `gorm:"not null;default:'queued'" json:"status"`
+	// Pages are the uploaded receipt files, deleted once the job finishes
+	Pages       []ParseJobPage  `gorm:"foreignKey:ParseJobID;constraint:OnDelete:CASCADE" json:"-"`
+	Result      json.RawMessage `gorm:"type:jsonb" json:"result,omitempty"`
+	Error       string          `gorm:"type:text" json:"error,omitempty"`
+	Attempts    int             `gorm:"not null;default:0" json:"attempts"`
+	WebhookURL  string          `json:"webhook_url,omitempty"`
+	CreatedAt   time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// ParseJobPage is one uploaded file of a receipt being parsed, e.g. one of
+// several photos of a long receipt
+type ParseJobPage struct {
+	ID          string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ParseJobID  string `gorm:"not null;index" json:"-"`
+	Position    int    `gorm:"not null" json:"position"`
+	ContentType string `gorm:"not null" json:"content_type"`
+	// Input is the base64 encoded file
+	Input string `gorm:"type:text;not null" json:"-"`
+}
+
+// ReceiptImage is an original receipt file, kept so it can be checked if a
+// split is disputed. It is stored when the receipt is parsed and attached to
+// the receipt created from the parse.
+type ReceiptImage struct {
+	ID           string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReceiptID    *string   `gorm:"type:uuid;index" json:"-"`
+	UserID       string    `gorm:"not null;index" json:"-"`
+	ParseJobID   *string   `gorm:"type:uuid;uniqueIndex:idx_receipt_image_page" json:"-"`
+	Position     int       `gorm:"not null;uniqueIndex:idx_receipt_image_page" json:"position"`
+	ContentType  string    `gorm:"not null" json:"content_type"`
+	Size         int       `gorm:"not null" json:"size"`
+	Key          string    `gorm:"not null" json:"-"`
+	ThumbnailKey string    `json:"-"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
@@ -0,0 +1,178 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// Money is an exact amount in the minor units (e.g. pence) of a currency
+type Money struct {
+	Amount   int64  `gorm:"not null;default:0" json:"amount"`
+	Currency string `gorm:"type:char(3);not null;default:'GBP'" json:"currency"`
+
+	// major holds a legacy decimal amount whose currency was not known when
+	// it was decoded; Resolve converts it into minor units
+	major string
+}
+
+// New creates an amount from minor units
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse converts a decimal string in major units (e.g. "12.34") into an
+// amount of the given currency, rounding half away from zero if it has more
+// decimal places than the currency allows
+func Parse(s, currency string) (Money, error) {
+	currency, err := NormaliseCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Money{}, ErrInvalidAmount
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(Exponent(currency))))
+
+	amount, err := Round(r)
+	if err != nil {
+		return Money{}, err
+	}
+	return New(amount, currency), nil
+}
+
+// Round rounds a rational number half away from zero
+func Round(r *big.Rat) (int64, error) {
+	num := new(big.Int).Abs(r.Num())
+	q, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if !q.IsInt64() {
+		return 0, ErrInvalidAmount
+	}
+	if r.Sign() < 0 {
+		return -q.Int64(), nil
+	}
+	return q.Int64(), nil
+}
+
+// pow10 returns 10^n
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Resolve fills in the currency of an amount decoded without one, converting
+// any legacy decimal amount into minor units of that currency
+func (m Money) Resolve(currency string) (Money, error) {
+	if m.major != "" {
+		return Parse(m.major, currency)
+	}
+	if m.Currency == "" {
+		m.Currency = currency
+	}
+	code, err := NormaliseCurrency(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	m.Currency = code
+	return m, nil
+}
+
+// Add returns the sum of two amounts in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return New(m.Amount+other.Amount, m.Currency), nil
+}
+
+// Mul returns the amount multiplied by a whole number
+func (m Money) Mul(n int64) Money {
+	return New(m.Amount*n, m.Currency)
+}
+
+// Neg returns the amount with its sign flipped
+func (m Money) Neg() Money {
+	return New(-m.Amount, m.Currency)
+}
+
+// Abs returns the absolute amount
+func (m Money) Abs() Money {
+	if m.Amount < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// Decimal formats the amount in major units, e.g. "12.34"
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	unit := pow10(exp).Int64()
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exp, amount%unit)
+}
+
+// String formats the amount with its currency, e.g. "12.34 GBP"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// UnmarshalJSON accepts {"amount": 1234, "currency": "GBP"} as well as the
+// legacy formats of a bare major-unit number (12.34) or numeric string
+// ("12.34"), whose currency is filled in later by Resolve
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*m = Money{}
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Amount   json.Number `json:"amount"`
+			Currency string      `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		amount, err := v.Amount.Int64()
+		if err != nil {
+			return ErrInvalidAmount
+		}
+		*m = Money{Amount: amount, Currency: strings.ToUpper(v.Currency)}
+		return nil
+	}
+
+	var s string
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else {
+		s = string(data)
+	}
+	if _, ok := new(big.Rat).SetString(strings.TrimSpace(s)); !ok {
+		return ErrInvalidAmount
+	}
+	*m = Money{major: strings.TrimSpace(s)}
+	return nil
+}
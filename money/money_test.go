@@ -0,0 +1,129 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s, currency string
+		want        Money
+		err         error
+	}{
+		{"12.34", "GBP", New(1234, "GBP"), nil},
+		{"12", "GBP", New(1200, "GBP"), nil},
+		{" 7.5 ", "gbp", New(750, "GBP"), nil},
+		{"-3.21", "EUR", New(-321, "EUR"), nil},
+		{"0.005", "USD", New(1, "USD"), nil},
+		{"12.345", "GBP", New(1235, "GBP"), nil},
+		{"-12.345", "GBP", New(-1235, "GBP"), nil},
+		{"12.344", "GBP", New(1234, "GBP"), nil},
+		{"1000", "JPY", New(1000, "JPY"), nil},
+		{"1.5", "JPY", New(2, "JPY"), nil},
+		{"1.2345", "BHD", New(1235, "BHD"), nil},
+		{"abc", "GBP", Money{}, ErrInvalidAmount},
+		{"", "GBP", Money{}, ErrInvalidAmount},
+		{"1.00", "XYZ", Money{}, ErrUnknownCurrency},
+		{"1e30", "GBP", Money{}, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.s, tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Parse() error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		num, denom int64
+		want       int64
+	}{
+		{5, 1, 5},
+		{5, 2, 3},
+		{-5, 2, -3},
+		{7, 3, 2},
+		{-7, 3, -2},
+		{8, 3, 3},
+		{-8, 3, -3},
+		{1, 2, 1},
+		{-1, 2, -1},
+		{1, 3, 0},
+		{0, 1, 0},
+	}
+
+	for _, tt := range tests {
+		r := big.NewRat(tt.num, tt.denom)
+		got, err := Round(r)
+		if err != nil {
+			t.Errorf("Round(%s) error = %v", r, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Round(%s) = %d, want %d", r, got, tt.want)
+		}
+	}
+
+	huge, _ := new(big.Rat).SetString("1e30")
+	if _, err := Round(huge); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Round(1e30) error = %v, want %v", err, ErrInvalidAmount)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(1234, "GBP"), "12.34"},
+		{New(5, "GBP"), "0.05"},
+		{New(-5, "GBP"), "-0.05"},
+		{New(-1234, "EUR"), "-12.34"},
+		{New(1000, "JPY"), "1000"},
+		{New(1235, "BHD"), "1.235"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.want {
+			t.Errorf("%#v.Decimal() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json     string
+		currency string
+		want     Money
+	}{
+		{`{"amount": 1234, "currency": "GBP"}`, "EUR", New(1234, "GBP")},
+		{`{"amount": 1234}`, "EUR", New(1234, "EUR")},
+		{`12.34`, "GBP", New(1234, "GBP")},
+		{`"12.34"`, "JPY", New(12, "JPY")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var m Money
+			if err := json.Unmarshal([]byte(tt.json), &m); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			got, err := m.Resolve(tt.currency)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Percent is an exact percentage stored in basis points (hundredths of a
+// percent), so 12.5% is Percent(1250)
+type Percent int64
+
+// ParsePercent converts a decimal percentage such as "12.5" to basis points
+func ParsePercent(s string) (Percent, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrInvalidAmount
+	}
+	bp, err := Round(r.Mul(r, big.NewRat(100, 1)))
+	if err != nil {
+		return 0, err
+	}
+	return Percent(bp), nil
+}
+
+// Of returns the percentage of an amount, rounded half away from zero
+func (p Percent) Of(amount int64) int64 {
+	r := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(amount), big.NewInt(int64(p))), big.NewInt(10000))
+	v, _ := Round(r)
+	return v
+}
+
+// String formats the percentage as a decimal, e.g. "12.5"
+func (p Percent) String() string {
+	return new(big.Rat).SetFrac64(int64(p), 100).FloatString(2)
+}
+
+// MarshalJSON encodes the percentage as a plain number
+func (p Percent) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(p)/100, 'f', -1, 64)), nil
+}
+
+// UnmarshalJSON decodes a number or numeric string without going through float64
+func (p *Percent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := ParsePercent(s)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+// Value stores the percentage in a numeric column
+func (p Percent) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+// Scan reads the percentage from a numeric column
+func (p *Percent) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	default:
+		return fmt.Errorf("cannot scan %T into Percent", src)
+	}
+	v, err := ParsePercent(s)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
@@ -0,0 +1,92 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const googleVisionURL = "https://vision.googleapis.com/v1/images:annotate"
+
+// GoogleVisionProvider reads text using Google Cloud Vision
+type GoogleVisionProvider struct {
+	apiKey string
+	client *http.Client
+}
+
+// NewGoogleVisionProvider creates a provider authenticating with apiKey
+func NewGoogleVisionProvider(apiKey string) *GoogleVisionProvider {
+	return &GoogleVisionProvider{
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Text implements Provider
+func (p *GoogleVisionProvider) Text(ctx context.Context, image []byte) (string, error) {
+	requestBody := map[string]interface{}{
+		"requests": []map[string]interface{}{
+			{
+				"image": map[string]string{"content": base64.StdEncoding.EncodeToString(image)},
+				"features": []map[string]interface{}{
+					{"type": "TEXT_DETECTION", "maxResults": 1},
+				},
+			},
+		},
+	}
+
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleVisionURL, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Sent as a header rather than in the URL so it stays out of logs
+	req.Header.Set("X-Goog-Api-Key", p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("Google Vision API error: %s", body)
+	}
+
+	var result struct {
+		Responses []struct {
+			FullTextAnnotation *struct {
+				Text string `json:"text"`
+			} `json:"fullTextAnnotation"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"responses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Responses) == 0 {
+		return "", fmt.Errorf("invalid Google Vision API response")
+	}
+
+	response := result.Responses[0]
+	if response.Error != nil {
+		return "", fmt.Errorf("Google Vision API error: %s", response.Error.Message)
+	}
+	if response.FullTextAnnotation == nil || response.FullTextAnnotation.Text == "" {
+		return "", ErrNoText
+	}
+	return response.FullTextAnnotation.Text, nil
+}
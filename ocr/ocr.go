@@ -0,0 +1,43 @@
+package ocr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrNoText = errors.New("no text found in image")
+
+// Provider extracts the text from an image
+type Provider interface {
+	// Text returns the text in an encoded image (JPEG, PNG etc.), with lines
+	// in reading order separated by newlines
+	Text(ctx context.Context, image []byte) (string, error)
+}
+
+// Config selects and configures an OCR provider
+type Config struct {
+	// Provider is "google" (the default) or "tesseract"
+	Provider string
+	// GoogleAPIKey authenticates requests to Google Cloud Vision
+	GoogleAPIKey string
+	// TesseractPath is the tesseract binary, looked up on PATH if empty
+	TesseractPath string
+	// TesseractLanguages are the tesseract language codes to use, e.g. "eng+fra"
+	TesseractLanguages string
+}
+
+// NewProvider creates the provider named in cfg
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "", "google":
+		if cfg.GoogleAPIKey == "" {
+			return nil, errors.New("Google Vision needs an API key")
+		}
+		return NewGoogleVisionProvider(cfg.GoogleAPIKey), nil
+	case "tesseract":
+		return NewTesseractProvider(cfg.TesseractPath, cfg.TesseractLanguages)
+	default:
+		return nil, fmt.Errorf("unknown OCR provider %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,140 @@
+package ocr
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// minOverlap is the fewest matching lines treated as an overlap, so a
+	// single repeated item isn't mistaken for one
+	minOverlap = 2
+	// maxCutLines is how many lines at a photo's edge may be cut off and
+	// garbled, and so are ignored when looking for an overlap
+	maxCutLines = 2
+	// lineSimilarity is how alike two lines must be to count as the same
+	// line read twice, allowing for OCR noise
+	lineSimilarity = 0.8
+)
+
+// Stitch joins the text of several photos of one receipt, in order. Lines
+// that appear at the bottom of one photo and the top of the next are kept
+// only once. It also reports, for each photo after the first, whether an
+// overlap with the one before was found.
+func Stitch(texts []string) (string, []bool) {
+	if len(texts) == 0 {
+		return "", nil
+	}
+
+	merged := splitLines(texts[0])
+	overlaps := make([]bool, len(texts))
+	overlaps[0] = true
+	for i, text := range texts[1:] {
+		next := splitLines(text)
+		keepA, skipB, ok := findOverlap(merged, next)
+		overlaps[i+1] = ok
+		if ok {
+			merged = append(merged[:keepA], next[skipB:]...)
+		} else {
+			merged = append(merged, next...)
+		}
+	}
+	return strings.Join(merged, "\n"), overlaps
+}
+
+// findOverlap looks for the longest run of lines ending a (give or take a few
+// cut off lines) that also starts b. It returns how many lines of a to keep
+// and how many of b to drop. Lines with no letters or digits, such as
+// separators, are left out of the comparison, since they all look alike.
+func findOverlap(a, b []string) (int, int, bool) {
+	normA, indexA := contentLines(a)
+	normB, indexB := contentLines(b)
+
+	bestLen, keepA, skipB := 0, 0, 0
+	for cutA := 0; cutA <= maxCutLines && cutA < len(normA); cutA++ {
+		for cutB := 0; cutB <= maxCutLines && cutB < len(normB); cutB++ {
+			endA := len(normA) - cutA
+			for n := min(endA, len(normB)-cutB); n >= minOverlap && n > bestLen; n-- {
+				if linesMatch(normA[endA-n:endA], normB[cutB:cutB+n]) {
+					// Keep a's copy of the overlap and drop b's
+					bestLen, keepA, skipB = n, indexA[endA-1]+1, indexB[cutB+n-1]+1
+					break
+				}
+			}
+		}
+	}
+	return keepA, skipB, bestLen > 0
+}
+
+// contentLines normalises lines, leaving out those with nothing left, and
+// returns where each kept line came from
+func contentLines(lines []string) ([][]rune, []int) {
+	var normalised [][]rune
+	var index []int
+	for i, line := range lines {
+		if n := normaliseLine(line); len(n) > 0 {
+			normalised = append(normalised, n)
+			index = append(index, i)
+		}
+	}
+	return normalised, index
+}
+
+func linesMatch(a, b [][]rune) bool {
+	for i := range a {
+		if similarity(a[i], b[i]) < lineSimilarity {
+			return false
+		}
+	}
+	return true
+}
+
+func splitLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// normaliseLine lower-cases a line and drops its spacing and punctuation,
+// which OCR reads least consistently
+func normaliseLine(line string) []rune {
+	normalised := []rune{}
+	for _, r := range strings.ToLower(line) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalised = append(normalised, r)
+		}
+	}
+	return normalised
+}
+
+// similarity is 1 minus the edit distance between a and b relative to the
+// longer of them
+func similarity(a, b []rune) float64 {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 1
+	}
+
+	// Levenshtein distance, keeping one row at a time
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(b)])/float64(longest)
+}
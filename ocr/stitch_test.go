@@ -0,0 +1,104 @@
+package ocr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStitch(t *testing.T) {
+	tests := []struct {
+		name     string
+		texts    []string
+		want     string
+		overlaps []bool
+	}{
+		{
+			name:     "nothing to stitch",
+			texts:    nil,
+			want:     "",
+			overlaps: nil,
+		},
+		{
+			name:     "single photo",
+			texts:    []string{"Coffee 3.00\n\n  Cake 4.50  "},
+			want:     "Coffee 3.00\nCake 4.50",
+			overlaps: []bool{true},
+		},
+		{
+			name: "overlapping lines are kept once",
+			texts: []string{
+				"Cafe\nCoffee 3.00\nCake 4.50\nTea 2.00",
+				"Cake 4.50\nTea 2.00\nTotal 9.50",
+			},
+			want:     "Cafe\nCoffee 3.00\nCake 4.50\nTea 2.00\nTotal 9.50",
+			overlaps: []bool{true, true},
+		},
+		{
+			name: "OCR noise still matches",
+			texts: []string{
+				"Coffee 3.00\nCake 4.50\nTea 2.00",
+				"Cake 4,50\nTea  2.0O\nTotal 9.50",
+			},
+			want:     "Coffee 3.00\nCake 4.50\nTea 2.00\nTotal 9.50",
+			overlaps: []bool{true, true},
+		},
+		{
+			name: "garbled edge lines are dropped",
+			texts: []string{
+				"Coffee 3.00\nCake 4.50\nTea 2.00\n~~#~~",
+				"%%//\nCake 4.50\nTea 2.00\nTotal 9.50",
+			},
+			want:     "Coffee 3.00\nCake 4.50\nTea 2.00\nTotal 9.50",
+			overlaps: []bool{true, true},
+		},
+		{
+			name: "one repeated line isn't an overlap",
+			texts: []string{
+				"Coffee 3.00\nTea 2.00",
+				"Tea 2.00\nTotal 5.00",
+			},
+			want:     "Coffee 3.00\nTea 2.00\nTea 2.00\nTotal 5.00",
+			overlaps: []bool{true, false},
+		},
+		{
+			name: "separator lines aren't an overlap",
+			texts: []string{
+				"Coffee 3.00\n-----\n=====",
+				"-----\n=====\nTotal 3.00",
+			},
+			want:     "Coffee 3.00\n-----\n=====\n-----\n=====\nTotal 3.00",
+			overlaps: []bool{true, false},
+		},
+		{
+			name: "separators inside an overlap are ignored",
+			texts: []string{
+				"Coffee 3.00\nCake 4.50\n-----\nTea 2.00",
+				"Cake 4.50\nTea 2.00\n=====\nTotal 9.50",
+			},
+			want:     "Coffee 3.00\nCake 4.50\n-----\nTea 2.00\n=====\nTotal 9.50",
+			overlaps: []bool{true, true},
+		},
+		{
+			name: "photos without an overlap are joined",
+			texts: []string{
+				"Coffee 3.00\nCake 4.50",
+				"Sandwich 6.00\nTotal 13.50",
+				"Sandwich 6.00\nTotal 13.50\nThank you",
+			},
+			want:     "Coffee 3.00\nCake 4.50\nSandwich 6.00\nTotal 13.50\nThank you",
+			overlaps: []bool{true, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, overlaps := Stitch(tt.texts)
+			if got != tt.want {
+				t.Errorf("Stitch() text = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(overlaps, tt.overlaps) {
+				t.Errorf("Stitch() overlaps = %v, want %v", overlaps, tt.overlaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// TesseractProvider reads text by running the tesseract command line tool,
+// so receipts can be parsed without a cloud account
+type TesseractProvider struct {
+	path      string
+	languages string
+}
+
+// NewTesseractProvider creates a provider running the tesseract binary at
+// path, or the one on PATH if path is empty. languages defaults to "eng".
+func NewTesseractProvider(path, languages string) (*TesseractProvider, error) {
+	if path == "" {
+		path = "tesseract"
+	}
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found: %w", err)
+	}
+	if languages == "" {
+		languages = "eng"
+	}
+	return &TesseractProvider{path: resolved, languages: languages}, nil
+}
+
+// Text implements Provider
+func (p *TesseractProvider) Text(ctx context.Context, image []byte) (string, error) {
+	// Page segmentation mode 4 treats the image as a single column of text
+	// of variable sizes, which suits receipts best
+	cmd := exec.CommandContext(ctx, p.path, "stdin", "stdout", "-l", p.languages, "--psm", "4")
+	cmd.Stdin = bytes.NewReader(image)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	text := strings.TrimSpace(stdout.String())
+	if text == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
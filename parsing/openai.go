@@ -0,0 +1,213 @@
+package parsing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"receipt-splitter-backend/money"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const systemPrompt = `
+You are a highly intelligent receipt parsing assistant. Your task is to analyze the provided receipt text and return a structured JSON object with the following format:
+          {
+            "name": "Store Name",
+            "currency": "ISO 4217 Currency Code",
+            "modifiers": [
+              {"type": "Modifier Type", "value": Value, "percentage": PercentageOfOrder (if applicable)}
+            ],
+            "items": [
+              {"item": "Item Name", "price": PricePerItem, "qty": Quantity}
+            ],
+            "subtotal": PrintedSubtotal,
+            "total": PrintedTotal
+          }
+          Important Considerations:
+          Store Name:
+          Extract the store's name from the receipt header or footer, wherever applicable.
+          Currency:
+          The three-letter ISO 4217 code of the currency the receipt is in (e.g. "GBP", "EUR", "USD"), inferred from currency symbols or the store's location. Default to "GBP" if unclear.
+          Modifiers:
+          Include all price-related adjustments as separate entries in the modifiers array. Each modifier should include:
+          type: The name of the modifier (e.g., "Service Charge", "Discount").
+          value: The absolute value of the modifier (e.g., £10.00 for a discount or service charge).
+          percentage: If the modifier is a percentage of the total order, include the percentage. If not, set this field to null.
+          Items:
+          Each item should include:
+          item: The item's name, accurately extracted even if split across multiple lines.
+          price: The price per unit of the item. If the price is for multiple units, divide the total price by the quantity to calculate the per-item price. This should not include the currency, just the value.
+          qty: The quantity of the item. Ensure the correct quantity, even if quantities are specified on separate lines or implied by additional notes like "x2" or "double."
+          Subtotal and Total:
+          The subtotal and the final total exactly as printed on the receipt, or null if not printed. Do not calculate them yourself; they are used to check the items and modifiers.
+          Handle cases where:
+          The price is listed per line (inclusive or exclusive of totals).
+          Adjustments (e.g., additions, subtractions, or discounts) are listed on sublines or as notes.
+          Format Adaptation:
+          Some receipts might have irregular formats, such as handwritten-style totals, unclear item groupings, or totals including service charges. Adapt accordingly and infer missing information where possible.
+          Tax:
+          If tax is explicitly mentioned, include it as a modifier in the modifiers array with type: "Tax". Specify the tax value and its percentage of the total (if applicable).
+          Error Handling:
+          If any field cannot be confidently extracted, provide a null value for that field in the JSON and note the reason in a separate "notes" field.
+`
+
+// receiptSchema constrains the model's reply to the shape rawReceipt
+// expects. Structured outputs need every property listed as required, so
+// optional values are nullable instead.
+var receiptSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"name": {"type": ["string", "null"]},
+		"currency": {"type": ["string", "null"], "description": "ISO 4217 code"},
+		"items": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"item": {"type": "string"},
+					"price": {"type": "number", "description": "Price per unit in major units"},
+					"qty": {"type": "integer"}
+				},
+				"required": ["item", "price", "qty"],
+				"additionalProperties": false
+			}
+		},
+		"modifiers": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"type": {"type": "string"},
+					"value": {"type": "number"},
+					"percentage": {"type": ["number", "null"]}
+				},
+				"required": ["type", "value", "percentage"],
+				"additionalProperties": false
+			}
+		},
+		"subtotal": {"type": ["number", "null"], "description": "Subtotal as printed"},
+		"total": {"type": ["number", "null"], "description": "Total as printed"},
+		"notes": {"type": ["string", "null"]}
+	},
+	"required": ["name", "currency", "items", "modifiers", "subtotal", "total", "notes"],
+	"additionalProperties": false
+}`)
+
+// OpenAIStructurer asks a chat model to structure receipts. It works with
+// OpenAI itself and with any server exposing the same API.
+type OpenAIStructurer struct {
+	client *openai.Client
+	model  string
+	format *openai.ChatCompletionResponseFormat
+	// RepromptThreshold, if set, is how far the parsed lines may be from
+	// the printed total, as a percentage of it, before the model is asked to
+	// read the receipt again
+	RepromptThreshold money.Percent
+}
+
+// NewOpenAIStructurer creates a structurer using the given model, or a
+// GPT-4o snapshot that supports structured outputs if it is empty. An empty
+// baseURL means OpenAI's own API, whose replies are held to receiptSchema;
+// other servers are only asked for JSON, as support for schemas varies.
+func NewOpenAIStructurer(apiKey, baseURL, model string) *OpenAIStructurer {
+	config := openai.DefaultConfig(apiKey)
+	format := &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   "receipt",
+			Schema: receiptSchema,
+			Strict: true,
+		},
+	}
+	if baseURL != "" {
+		config.BaseURL = baseURL
+		format = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	if model == "" {
+		// chatgpt-4o-latest rejects strict JSON schemas, so pin a snapshot
+		model = openai.GPT4o20240806
+	}
+	return &OpenAIStructurer{client: openai.NewClientWithConfig(config), model: model, format: format}
+}
+
+// Structure implements ReceiptStructurer
+func (s *OpenAIStructurer) Structure(ctx context.Context, text string) (*ParsedReceipt, error) {
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf("Here is the extracted text from a receipt, ONLY PROVIDE ME THE JSON OBJECT NOTHING ELSE:\n\n %s", text),
+		},
+	}
+
+	reply, err := s.complete(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := decodeReceiptJSON(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	r := parsed.Reconciliation
+	if s.RepromptThreshold <= 0 || r == nil || r.Matches || abs(r.Delta.Amount) <= s.RepromptThreshold.Of(abs(r.Expected.Amount)) {
+		return parsed, nil
+	}
+
+	// Show the model where it went wrong and let it have another go
+	messages = append(messages,
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply},
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: repromptMessage(r)},
+	)
+	reply, err = s.complete(ctx, messages)
+	if err != nil {
+		return parsed, nil
+	}
+	retried, err := decodeReceiptJSON(reply)
+	if err != nil || retried.Reconciliation == nil || abs(retried.Reconciliation.Delta.Amount) >= abs(r.Delta.Amount) {
+		return parsed, nil
+	}
+	retried.Warn("reconciliation", "re-read after the first reading was %s out", r.Delta.Abs())
+	return retried, nil
+}
+
+// complete sends a conversation to the model and returns its reply
+func (s *OpenAIStructurer) complete(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
+	resp, err := s.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:          s.model,
+			ResponseFormat: s.format,
+			Messages:       messages,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no response from model")
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// repromptMessage explains a reconciliation mismatch to the model
+func repromptMessage(r *Reconciliation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your items and modifiers add up to %s, but the %s printed on the receipt is %s.", r.Computed, r.Against, r.Expected)
+	if len(r.Culprits) > 0 {
+		b.WriteString(" Possible causes:")
+		for _, c := range r.Culprits {
+			fmt.Fprintf(&b, "\n- %s %s", c.Field, c.Reason)
+		}
+	}
+	b.WriteString("\nRe-read the receipt text, fix any missing, duplicated or misread lines, and reply with the corrected JSON object only. If the receipt really doesn't add up, keep what is printed.")
+	return b.String()
+}
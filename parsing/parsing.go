@@ -0,0 +1,82 @@
+package parsing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"receipt-splitter-backend/money"
+)
+
+var ErrNoReceipt = errors.New("no receipt found in text")
+
+// ReceiptStructurer turns the text read from a receipt into structured data
+type ReceiptStructurer interface {
+	// Structure returns the receipt's name, currency, items and modifiers,
+	// with warnings about anything that had to be corrected
+	Structure(ctx context.Context, text string) (*ParsedReceipt, error)
+}
+
+// Config selects and configures a receipt structurer
+type Config struct {
+	// Provider is "openai" (the default), "openai-compatible" for a local
+	// server such as llama.cpp or Ollama, or "rules" for the offline parser
+	Provider string
+	APIKey   string
+	// BaseURL is the OpenAI-compatible API root, e.g. http://localhost:11434/v1
+	BaseURL string
+	// Model overrides the provider's default model
+	Model string
+	// RepromptThreshold is the percentage of the printed total the parsed
+	// lines may be out by before the model is asked to try again; zero
+	// turns re-prompting off
+	RepromptThreshold money.Percent
+}
+
+// NewStructurer creates the structurer named in cfg
+func NewStructurer(cfg Config) (ReceiptStructurer, error) {
+	switch cfg.Provider {
+	case "", "openai":
+		if cfg.APIKey == "" {
+			return nil, errors.New("OpenAI needs an API key")
+		}
+		structurer := NewOpenAIStructurer(cfg.APIKey, "", cfg.Model)
+		structurer.RepromptThreshold = cfg.RepromptThreshold
+		return structurer, nil
+	case "openai-compatible":
+		if cfg.BaseURL == "" {
+			return nil, errors.New("an OpenAI-compatible server needs a base URL")
+		}
+		if cfg.Model == "" {
+			return nil, errors.New("an OpenAI-compatible server needs a model")
+		}
+		structurer := NewOpenAIStructurer(cfg.APIKey, cfg.BaseURL, cfg.Model)
+		structurer.RepromptThreshold = cfg.RepromptThreshold
+		return structurer, nil
+	case "rules":
+		return RuleStructurer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown receipt structurer %q", cfg.Provider)
+	}
+}
+
+// decodeReceiptJSON reads a JSON receipt from a model's reply, which may be
+// wrapped in a Markdown code fence, and validates it
+func decodeReceiptJSON(content string) (*ParsedReceipt, error) {
+	cleaned := strings.TrimSpace(content)
+	cleaned = strings.TrimPrefix(cleaned, "```json")
+	cleaned = strings.TrimPrefix(cleaned, "```")
+	cleaned = strings.TrimSuffix(cleaned, "```")
+
+	// Keep numbers exact
+	var raw rawReceipt
+	decoder := json.NewDecoder(strings.NewReader(cleaned))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse model response: %v", err)
+	}
+
+	return validate(raw), nil
+}
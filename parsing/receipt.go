@@ -0,0 +1,272 @@
+package parsing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"receipt-splitter-backend/money"
+)
+
+// ParsedReceipt is a receipt read from an image, checked and cleaned up.
+// Items and modifiers use the same JSON shape as a new receipt, so clients
+// can send them straight back once the user has reviewed them.
+type ParsedReceipt struct {
+	Name      string           `json:"name"`
+	Currency  string           `json:"currency"`
+	Items     []ParsedItem     `json:"items"`
+	Modifiers []ParsedModifier `json:"modifiers"`
+	// Subtotal and Total are as printed on the receipt, if found
+	Subtotal       *money.Money    `json:"subtotal,omitempty"`
+	Total          *money.Money    `json:"total,omitempty"`
+	Reconciliation *Reconciliation `json:"reconciliation,omitempty"`
+	Notes          string          `json:"notes,omitempty"`
+	// Warnings point out fields that were missing or had to be corrected,
+	// so the user knows what to check
+	Warnings []Warning `json:"warnings"`
+}
+
+// ParsedItem is a line item on a parsed receipt
+type ParsedItem struct {
+	Item  string      `json:"item"`
+	Price money.Money `json:"price"`
+	Qty   int         `json:"qty"`
+}
+
+// ParsedModifier is a charge or discount on a parsed receipt
+type ParsedModifier struct {
+	Type       string         `json:"type"`
+	Value      money.Money    `json:"value"`
+	Percentage *money.Percent `json:"percentage,omitempty"`
+}
+
+// Warning describes a problem with one field of a parsed receipt, e.g.
+// {"field": "items[2].qty", "message": "missing, assumed 1"}
+type Warning struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Warn records a warning about a field
+func (p *ParsedReceipt) Warn(field, format string, args ...interface{}) {
+	p.Warnings = append(p.Warnings, Warning{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// maxItemQty is the largest quantity believed for one line
+const maxItemQty = 1000
+
+var decimalComma = regexp.MustCompile(`^[^,]*,\d{2}\D*$`)
+
+// modifierKinds are the keywords of the modifier types we know how to treat
+var modifierKinds = []string{"service", "tip", "gratuity", "tax", "vat", "gst", "discount", "deduction", "voucher", "promo", "coupon", "refund", "off", "delivery", "fee", "charge", "deposit"}
+
+// rawReceipt is a receipt as a model or parser produced it, before it has
+// been checked. Values decoded from JSON are json.Number, string or nil.
+type rawReceipt struct {
+	Name      *string       `json:"name"`
+	Currency  *string       `json:"currency"`
+	Items     []rawItem     `json:"items"`
+	Modifiers []rawModifier `json:"modifiers"`
+	Subtotal  interface{}   `json:"subtotal"`
+	Total     interface{}   `json:"total"`
+	Notes     *string       `json:"notes"`
+}
+
+type rawItem struct {
+	Item  *string     `json:"item"`
+	Price interface{} `json:"price"`
+	Qty   interface{} `json:"qty"`
+}
+
+type rawModifier struct {
+	Type       *string     `json:"type"`
+	Value      interface{} `json:"value"`
+	Percentage interface{} `json:"percentage"`
+}
+
+// validate checks a raw receipt, fixing what it safely can and warning about
+// everything it changes or drops
+func validate(raw rawReceipt) *ParsedReceipt {
+	parsed := &ParsedReceipt{
+		Items:     []ParsedItem{},
+		Modifiers: []ParsedModifier{},
+		Warnings:  []Warning{},
+	}
+
+	parsed.Name = strings.TrimSpace(deref(raw.Name))
+	if parsed.Name == "" {
+		parsed.Warn("name", "missing")
+	}
+	parsed.Notes = strings.TrimSpace(deref(raw.Notes))
+
+	currency, err := money.NormaliseCurrency(deref(raw.Currency))
+	if err != nil || raw.Currency == nil || *raw.Currency == "" {
+		currency = money.DefaultCurrency
+		parsed.Warn("currency", "missing or unknown, assumed %s", currency)
+	}
+	parsed.Currency = currency
+
+	var subtotal int64
+	for i, item := range raw.Items {
+		field := fmt.Sprintf("items[%d]", i)
+
+		price, err := parseAmount(item.Price, currency)
+		if err != nil {
+			parsed.Warn(field+".price", "%v; item dropped", err)
+			continue
+		}
+		if price.Amount < 0 {
+			parsed.Warn(field+".price", "negative; this may be a discount")
+		}
+
+		name := strings.TrimSpace(deref(item.Item))
+		if name == "" {
+			name = fmt.Sprintf("Item %d", i+1)
+			parsed.Warn(field+".item", "missing, named %q", name)
+		}
+
+		qty, err := parseNumber(item.Qty)
+		switch {
+		case err != nil:
+			qty = big.NewRat(1, 1)
+			parsed.Warn(field+".qty", "%v, assumed 1", err)
+		case qty.Sign() <= 0:
+			qty = big.NewRat(1, 1)
+			parsed.Warn(field+".qty", "not positive, assumed 1")
+		case qty.Cmp(big.NewRat(maxItemQty, 1)) > 0:
+			// Too many to be a real line; most likely a misread price or code
+			qty = big.NewRat(1, 1)
+			parsed.Warn(field+".qty", "more than %d, assumed 1", maxItemQty)
+		case !qty.IsInt():
+			// Weighed goods and the like: charge the whole line as one unit
+			total, _ := money.Round(new(big.Rat).Mul(qty, new(big.Rat).SetInt64(price.Amount)))
+			parsed.Warn(field+".qty", "%s is not a whole number, item priced as 1", qty.FloatString(3))
+			price.Amount = total
+			qty = big.NewRat(1, 1)
+		}
+
+		n := int(qty.Num().Int64())
+		subtotal += price.Amount * int64(n)
+		parsed.Items = append(parsed.Items, ParsedItem{Item: name, Price: price, Qty: n})
+	}
+	if len(parsed.Items) == 0 {
+		parsed.Warn("items", "no items found")
+	}
+
+	for i, modifier := range raw.Modifiers {
+		field := fmt.Sprintf("modifiers[%d]", i)
+
+		kind := strings.TrimSpace(deref(modifier.Type))
+		if kind == "" {
+			kind = "Adjustment"
+			parsed.Warn(field+".type", "missing, named %q", kind)
+		} else if !knownModifier(kind) {
+			parsed.Warn(field+".type", "%q is not a recognised charge or discount", kind)
+		}
+
+		var percentage *money.Percent
+		if modifier.Percentage != nil {
+			p, err := parsePercent(modifier.Percentage)
+			if err != nil || p <= 0 || p > 10000 {
+				parsed.Warn(field+".percentage", "%v is not a valid percentage; ignored", modifier.Percentage)
+			} else {
+				percentage = &p
+			}
+		}
+
+		value, err := parseAmount(modifier.Value, currency)
+		if err != nil {
+			if percentage == nil {
+				parsed.Warn(field+".value", "%v; modifier dropped", err)
+				continue
+			}
+			value = money.New(percentage.Of(subtotal), currency)
+			parsed.Warn(field+".value", "%v, worked out from the percentage", err)
+		}
+
+		parsed.Modifiers = append(parsed.Modifiers, ParsedModifier{Type: kind, Value: value, Percentage: percentage})
+	}
+
+	printed := func(field string, v interface{}) *money.Money {
+		if v == nil {
+			return nil
+		}
+		amount, err := parseAmount(v, currency)
+		if err != nil {
+			parsed.Warn(field, "%v; ignored", err)
+			return nil
+		}
+		return &amount
+	}
+	parsed.Subtotal = printed("subtotal", raw.Subtotal)
+	parsed.Total = printed("total", raw.Total)
+	parsed.Reconciliation = reconcile(parsed)
+
+	return parsed
+}
+
+// parseAmount reads a price in major units, tolerating currency symbols and
+// thousands separators in strings
+func parseAmount(v interface{}, currency string) (money.Money, error) {
+	r, err := parseNumber(v)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.Parse(r.FloatString(money.Exponent(currency)+2), currency)
+}
+
+// parsePercent reads a percentage, with or without a % sign
+func parsePercent(v interface{}) (money.Percent, error) {
+	r, err := parseNumber(v)
+	if err != nil {
+		return 0, err
+	}
+	return money.ParsePercent(r.FloatString(4))
+}
+
+// parseNumber reads an exact number from a decoded JSON value
+func parseNumber(v interface{}) (*big.Rat, error) {
+	var s string
+	switch value := v.(type) {
+	case nil:
+		return nil, fmt.Errorf("missing")
+	case json.Number:
+		s = value.String()
+	case string:
+		// A lone comma before two digits is a decimal comma, e.g. "3,50"
+		if !strings.Contains(value, ".") && decimalComma.MatchString(value) {
+			value = strings.Replace(value, ",", ".", 1)
+		}
+		s = strings.Map(func(r rune) rune {
+			if strings.ContainsRune("£$€₹%, ", r) {
+				return -1
+			}
+			return r
+		}, value)
+	case int:
+		return big.NewRat(int64(value), 1), nil
+	default:
+		return nil, fmt.Errorf("%v is not a number", v)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a number", fmt.Sprint(v))
+	}
+	return r, nil
+}
+
+// knownModifier reports whether a modifier type names a kind of charge or
+// discount we recognise
+func knownModifier(kind string) bool {
+	return containsWord(strings.ToLower(kind), modifierKinds)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
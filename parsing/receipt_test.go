@@ -0,0 +1,53 @@
+package parsing
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateQty(t *testing.T) {
+	tests := []struct {
+		name      string
+		qty       interface{}
+		wantQty   int
+		wantPrice int64
+		wantWarn  bool
+	}{
+		{"whole number", json.Number("3"), 3, 250, false},
+		{"as a string", "2", 2, 250, false},
+		{"at the limit", json.Number("1000"), 1000, 250, false},
+		{"missing", nil, 1, 250, true},
+		{"zero", json.Number("0"), 1, 250, true},
+		{"negative", json.Number("-2"), 1, 250, true},
+		{"over the limit", json.Number("1001"), 1, 250, true},
+		{"past int64", json.Number("99999999999999999999999"), 1, 250, true},
+		{"fractional", json.Number("0.5"), 1, 125, true},
+		{"huge fraction", json.Number("123456789012345678901.5"), 1, 250, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "Coffee"
+			parsed := validate(rawReceipt{Items: []rawItem{{Item: &name, Price: json.Number("2.50"), Qty: tt.qty}}})
+			if len(parsed.Items) != 1 {
+				t.Fatalf("got %d items, want 1", len(parsed.Items))
+			}
+			item := parsed.Items[0]
+			if item.Qty != tt.wantQty {
+				t.Errorf("Qty = %d, want %d", item.Qty, tt.wantQty)
+			}
+			if item.Price.Amount != tt.wantPrice {
+				t.Errorf("Price = %d, want %d", item.Price.Amount, tt.wantPrice)
+			}
+			warned := false
+			for _, w := range parsed.Warnings {
+				if w.Field == "items[0].qty" {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarn {
+				t.Errorf("qty warning = %v, want %v (warnings: %v)", warned, tt.wantWarn, parsed.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package parsing
+
+import (
+	"fmt"
+
+	"receipt-splitter-backend/money"
+	"receipt-splitter-backend/split"
+)
+
+// Reconciliation compares the total printed on a receipt with the total of
+// the items and modifiers that were read from it
+type Reconciliation struct {
+	// Against is "total" or "subtotal", whichever was printed
+	Against  string      `json:"against"`
+	Expected money.Money `json:"expected"`
+	Computed money.Money `json:"computed"`
+	// Delta is Expected - Computed: positive if something seems missing,
+	// negative if something seems counted twice
+	Delta   money.Money `json:"delta"`
+	Matches bool        `json:"matches"`
+	// Culprits are the lines most likely to explain the difference
+	Culprits []Culprit `json:"culprits,omitempty"`
+}
+
+// Culprit is a line that may explain a reconciliation difference
+type Culprit struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// modifierAmount is what a modifier adds to the bill, worked out the same way
+// as when the receipt is split
+func modifierAmount(m ParsedModifier, subtotal int64) int64 {
+	amount := m.Value.Abs().Amount
+	if m.Percentage != nil {
+		amount = m.Percentage.Of(subtotal)
+	}
+	if m.Value.Amount < 0 || split.IsDeduction(m.Type) {
+		return -amount
+	}
+	return amount
+}
+
+// reconcile checks the parsed lines against the printed totals. It returns
+// nil if the receipt had no printed total to check against.
+func reconcile(p *ParsedReceipt) *Reconciliation {
+	if p.Total == nil && p.Subtotal == nil {
+		return nil
+	}
+
+	var subtotal int64
+	for _, item := range p.Items {
+		subtotal += item.Price.Amount * int64(item.Qty)
+	}
+	total := subtotal
+	for _, m := range p.Modifiers {
+		total += modifierAmount(m, subtotal)
+	}
+
+	r := &Reconciliation{Against: "total", Computed: money.New(total, p.Currency)}
+	if p.Total != nil {
+		r.Expected = *p.Total
+	} else {
+		r.Against = "subtotal"
+		r.Expected = *p.Subtotal
+		r.Computed.Amount = subtotal
+	}
+	r.Delta = money.New(r.Expected.Amount-r.Computed.Amount, p.Currency)
+
+	// Unit prices worked out from line totals can each be a minor unit out
+	tolerance := int64(len(p.Items))
+	if tolerance < 1 {
+		tolerance = 1
+	}
+	r.Matches = abs(r.Delta.Amount) <= tolerance
+	if r.Matches {
+		return r
+	}
+
+	// If the items add up to the printed subtotal the problem is in the
+	// modifiers, and the other way round
+	itemsMatch := p.Subtotal != nil && abs(p.Subtotal.Amount-subtotal) <= tolerance
+	r.Culprits = culprits(p, r.Against == "subtotal" || !itemsMatch, subtotal, r.Delta.Amount)
+	return r
+}
+
+// culprits lists lines whose amounts would explain delta
+func culprits(p *ParsedReceipt, checkItems bool, subtotal, delta int64) []Culprit {
+	list := []Culprit{}
+	format := func(amount int64) string {
+		return money.New(amount, p.Currency).String()
+	}
+
+	if checkItems {
+		seen := map[string]int{}
+		for i, item := range p.Items {
+			field := fmt.Sprintf("items[%d]", i)
+			line := item.Price.Amount * int64(item.Qty)
+
+			key := fmt.Sprintf("%s|%d", item.Item, item.Price.Amount)
+			if delta < 0 && line == -delta {
+				if j, ok := seen[key]; ok {
+					list = append(list, Culprit{field, fmt.Sprintf("duplicates items[%d]; it may have been read twice", j)})
+				} else {
+					list = append(list, Culprit{field, fmt.Sprintf("costs %s, exactly the amount over; it may not be on the receipt", format(line))})
+				}
+			}
+			seen[key] = i
+
+			if item.Price.Amount != 0 && delta%item.Price.Amount == 0 {
+				if qty := int64(item.Qty) + delta/item.Price.Amount; qty > 0 {
+					list = append(list, Culprit{field + ".qty", fmt.Sprintf("a quantity of %d would match", qty)})
+				}
+			}
+		}
+		if delta > 0 {
+			list = append(list, Culprit{"items", fmt.Sprintf("%s more than the items add up to; an item may be missing", format(delta))})
+		}
+	}
+
+	for i, m := range p.Modifiers {
+		field := fmt.Sprintf("modifiers[%d]", i)
+		amount := modifierAmount(m, subtotal)
+
+		switch {
+		case amount == -delta:
+			list = append(list, Culprit{field, "accounts for the whole difference; it may be counted twice or already included in the prices"})
+		case delta%2 == 0 && amount == -delta/2:
+			list = append(list, Culprit{field, "looks like it has the wrong sign"})
+		case amount == delta:
+			list = append(list, Culprit{field, "may have been applied twice on the receipt"})
+		}
+	}
+
+	return list
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package parsing
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// priceLine matches a line ending in a price, e.g. "2 x Latte   £6.40"
+	priceLine = regexp.MustCompile(`^(?:(\d+)\s*[xX@]\s*|(\d+)\s+)?(.*?)[\s.:]*(-)?[£$€₹]?\s*(-?\d{1,6}[.,]\d{2})\s*(-)?\s*$`)
+	percent   = regexp.MustCompile(`(\d{1,2}(?:[.,]\d+)?)\s*%`)
+)
+
+// Words marking lines that summarise the receipt rather than list charges
+var summaryWords = []string{"subtotal", "sub total", "sub-total", "total", "balance", "change", "cash", "card", "visa", "mastercard", "amex", "paid", "tendered", "due"}
+
+// Words marking lines that adjust the bill rather than list items
+var modifierWords = []string{"service", "tip", "gratuity", "tax", "vat", "gst", "discount", "voucher", "promo", "coupon", "off"}
+
+var currencySymbols = []struct {
+	symbol   string
+	currency string
+}{
+	{"£", "GBP"},
+	{"€", "EUR"},
+	{"₹", "INR"},
+	{"$", "USD"},
+}
+
+// RuleStructurer parses receipts with fixed rules instead of a model. It is
+// far less capable, but needs no network access and always gives the same
+// answer, which suits self-hosting and tests.
+type RuleStructurer struct{}
+
+// Structure implements ReceiptStructurer
+func (RuleStructurer) Structure(ctx context.Context, text string) (*ParsedReceipt, error) {
+	name := ""
+	items := []rawItem{}
+	modifiers := []rawModifier{}
+	var subtotal, total interface{}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		match := priceLine.FindStringSubmatch(line)
+		if match == nil || strings.TrimSpace(match[3]) == "" {
+			// The first line without a price is usually the store's name
+			if name == "" {
+				name = line
+			}
+			continue
+		}
+
+		label := strings.TrimSpace(match[3])
+		lower := strings.ToLower(label)
+		value := strings.Replace(match[5], ",", ".", 1)
+		negative := match[4] != "" || match[6] != ""
+
+		if containsWord(lower, modifierWords) {
+			if negative && !strings.HasPrefix(value, "-") {
+				value = "-" + value
+			}
+			modifier := rawModifier{Type: &label, Value: json.Number(value)}
+			if p := percent.FindStringSubmatch(label); p != nil {
+				modifier.Percentage = json.Number(strings.Replace(p[1], ",", ".", 1))
+			}
+			modifiers = append(modifiers, modifier)
+			continue
+		}
+		if containsWord(lower, summaryWords) || negative {
+			switch {
+			case containsWord(lower, []string{"subtotal", "sub total", "sub-total"}):
+				subtotal = json.Number(value)
+			case containsWord(lower, []string{"total"}) && total == nil:
+				total = json.Number(value)
+			}
+			continue
+		}
+
+		qty := 1
+		for _, q := range match[1:3] {
+			if n, err := strconv.Atoi(q); err == nil && n > 0 {
+				qty = n
+			}
+		}
+		items = append(items, rawItem{Item: &label, Price: json.Number(unitPrice(value, qty)), Qty: json.Number(strconv.Itoa(qty))})
+	}
+
+	if len(items) == 0 {
+		return nil, ErrNoReceipt
+	}
+
+	currency := detectCurrency(text)
+	return validate(rawReceipt{
+		Name:      &name,
+		Currency:  &currency,
+		Items:     items,
+		Modifiers: modifiers,
+		Subtotal:  subtotal,
+		Total:     total,
+	}), nil
+}
+
+// unitPrice divides a line total by its quantity
+func unitPrice(total string, qty int) string {
+	r, ok := new(big.Rat).SetString(total)
+	if !ok || qty <= 1 {
+		return total
+	}
+	return r.Quo(r, big.NewRat(int64(qty), 1)).FloatString(4)
+}
+
+// containsWord reports whether any of words appears as a whole word in s
+func containsWord(s string, words []string) bool {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && r != '-'
+	})
+	for _, field := range fields {
+		for _, word := range words {
+			if field == word {
+				return true
+			}
+		}
+	}
+	for _, word := range words {
+		if strings.Contains(word, " ") && strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectCurrency guesses a receipt's currency from the symbols in it
+func detectCurrency(text string) string {
+	for _, c := range currencySymbols {
+		if strings.Contains(text, c.symbol) {
+			return c.currency
+		}
+	}
+	return ""
+}
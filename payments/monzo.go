@@ -0,0 +1,77 @@
+package payments
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+var monzoHostPrefixes = []string{"https://", "http://", "www.", "monzo.me/"}
+
+// usernamePattern matches the usernames accepted by payment-link services
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// MonzoProvider builds monzo.me payment requests, which only accept GBP
+type MonzoProvider struct{}
+
+func (MonzoProvider) Type() string { return TypeMonzo }
+
+func (MonzoProvider) Currencies() []string { return []string{"GBP"} }
+
+func (MonzoProvider) Normalise(method models.PaymentMethod) (models.PaymentMethod, error) {
+	username, err := NormaliseMonzoID(method.Handle)
+	if err != nil {
+		return method, err
+	}
+	method.Handle = username
+	return method, nil
+}
+
+func (MonzoProvider) Link(method models.PaymentMethod, amount money.Money, reference string) (Link, error) {
+	link, err := MonzoLink(method.Handle, amount, reference)
+	if err != nil {
+		return Link{}, err
+	}
+	return Link{Type: TypeMonzo, URL: link, Amount: amount, Reference: reference}, nil
+}
+
+// NormaliseMonzoID extracts the Monzo.me username from a stored ID, which
+// users enter as a bare username or as a full monzo.me link
+func NormaliseMonzoID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	for _, prefix := range monzoHostPrefixes {
+		if len(id) >= len(prefix) && strings.EqualFold(id[:len(prefix)], prefix) {
+			id = id[len(prefix):]
+		}
+	}
+	id = strings.TrimPrefix(id, "@")
+	if i := strings.IndexAny(id, "/?#"); i >= 0 {
+		id = id[:i]
+	}
+	if !usernamePattern.MatchString(id) {
+		return "", ErrInvalidHandle
+	}
+	return id, nil
+}
+
+// MonzoLink builds a monzo.me payment request for an amount with a
+// description shown to the payer. Monzo.me only accepts GBP.
+func MonzoLink(monzoID string, amount money.Money, reference string) (string, error) {
+	username, err := NormaliseMonzoID(monzoID)
+	if err != nil {
+		return "", err
+	}
+	if amount.Currency != "GBP" || amount.Amount <= 0 {
+		return "", ErrUnsupportedAmount
+	}
+
+	link := fmt.Sprintf("https://monzo.me/%s/%s", url.PathEscape(username), amount.Decimal())
+	if reference != "" {
+		link += "?d=" + url.QueryEscape(reference)
+	}
+	return link, nil
+}
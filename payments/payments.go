@@ -0,0 +1,56 @@
+package payments
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"receipt-splitter-backend/models"
+)
+
+// maxReferenceLength keeps references short enough for banking apps to show
+const maxReferenceLength = 60
+
+// Reference builds a payment reference from a receipt's name and reason
+func Reference(receipt models.Receipt) string {
+	return reference(receipt.Name, receipt.Reason)
+}
+
+// GroupReference builds a payment reference for settling up in a group
+func GroupReference(group models.Group) string {
+	return reference(group.Name, "settle up")
+}
+
+// reference joins the non-empty parts into a short reference
+func reference(parts ...string) string {
+	kept := []string{}
+	for _, s := range parts {
+		if s = strings.Join(strings.Fields(s), " "); s != "" {
+			kept = append(kept, s)
+		}
+	}
+	reference := strings.Join(kept, " - ")
+
+	if utf8.RuneCountInString(reference) > maxReferenceLength {
+		runes := []rune(reference)
+		reference = strings.TrimSpace(string(runes[:maxReferenceLength-1])) + "…"
+	}
+	return reference
+}
+
+// PayeeMethods returns the payment methods a receipt's payer accepts. A
+// Monzo.me ID set on the receipt overrides the payer's saved Monzo method.
+func PayeeMethods(receipt models.Receipt, methods []models.PaymentMethod) []models.PaymentMethod {
+	var result []models.PaymentMethod
+	id, err := NormaliseMonzoID(receipt.MonzoID)
+	override := err == nil
+	if override {
+		result = append(result, models.PaymentMethod{UserID: receipt.UserID, Type: TypeMonzo, Handle: id})
+	}
+	for _, m := range methods {
+		if m.Type == TypeMonzo && override {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package payments
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+// PayPalProvider builds paypal.me links, which carry the amount and
+// currency but no reference
+type PayPalProvider struct{}
+
+func (PayPalProvider) Type() string { return TypePayPal }
+
+// paypalCurrencies are the currencies PayPal accepts payments in
+var paypalCurrencies = []string{
+	"AUD", "BRL", "CAD", "CHF", "CNY", "CZK", "DKK", "EUR", "GBP", "HKD", "HUF", "ILS",
+	"JPY", "MXN", "MYR", "NOK", "NZD", "PHP", "PLN", "SEK", "SGD", "THB", "TWD", "USD",
+}
+
+// paypalWholeCurrencies are the currencies PayPal only takes whole amounts in
+var paypalWholeCurrencies = map[string]bool{"HUF": true, "TWD": true}
+
+func (PayPalProvider) Currencies() []string { return paypalCurrencies }
+
+func (PayPalProvider) Normalise(method models.PaymentMethod) (models.PaymentMethod, error) {
+	username, err := normaliseUsername(method.Handle, "paypal.me/", "www.paypal.me/", "paypal.com/paypalme/", "www.paypal.com/paypalme/")
+	if err != nil {
+		return method, err
+	}
+	method.Handle = username
+	return method, nil
+}
+
+func (PayPalProvider) Link(method models.PaymentMethod, amount money.Money, reference string) (Link, error) {
+	if amount.Amount <= 0 {
+		return Link{}, ErrUnsupportedAmount
+	}
+	decimal := amount.Decimal()
+	if paypalWholeCurrencies[amount.Currency] {
+		whole, fraction, _ := strings.Cut(decimal, ".")
+		if strings.Trim(fraction, "0") != "" {
+			return Link{}, ErrUnsupportedAmount
+		}
+		decimal = whole
+	}
+	link := fmt.Sprintf("https://paypal.me/%s/%s%s", url.PathEscape(method.Handle), decimal, amount.Currency)
+	return Link{Type: TypePayPal, URL: link, Amount: amount, Reference: reference}, nil
+}
+
+// normaliseUsername strips a scheme and any of the given host prefixes from
+// a username entered as a link
+func normaliseUsername(handle string, prefixes ...string) (string, error) {
+	handle = strings.TrimSpace(handle)
+	for _, scheme := range []string{"https://", "http://"} {
+		if len(handle) >= len(scheme) && strings.EqualFold(handle[:len(scheme)], scheme) {
+			handle = handle[len(scheme):]
+		}
+	}
+	for _, prefix := range prefixes {
+		if len(handle) >= len(prefix) && strings.EqualFold(handle[:len(prefix)], prefix) {
+			handle = handle[len(prefix):]
+			break
+		}
+	}
+	handle = strings.TrimPrefix(handle, "@")
+	if i := strings.IndexAny(handle, "/?#"); i >= 0 {
+		handle = handle[:i]
+	}
+	if !usernamePattern.MatchString(handle) {
+		return "", ErrInvalidHandle
+	}
+	return handle, nil
+}
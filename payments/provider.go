@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"errors"
+	"sort"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+// Payment method types
+const (
+	TypeMonzo   = "monzo"
+	TypePayPal  = "paypal"
+	TypeRevolut = "revolut"
+	TypeSEPA    = "sepa"
+	TypeUPI     = "upi"
+)
+
+var (
+	ErrUnknownType       = errors.New("unknown payment method type")
+	ErrInvalidHandle     = errors.New("invalid payment handle")
+	ErrUnsupportedAmount = errors.New("amount must be positive and in a supported currency")
+)
+
+// Link is a way for a participant to pay their share. Methods without a
+// standard link format, such as bank transfers, only fill in Details.
+type Link struct {
+	Type      string            `json:"type"`
+	URL       string            `json:"url,omitempty"`
+	Amount    money.Money       `json:"amount"`
+	Reference string            `json:"reference"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Provider builds payment requests for one type of payment method
+type Provider interface {
+	// Type is the payment method type this provider handles
+	Type() string
+	// Currencies lists the currencies the provider accepts; nil means any
+	Currencies() []string
+	// Normalise validates a method's details and returns them in canonical form
+	Normalise(method models.PaymentMethod) (models.PaymentMethod, error)
+	// Link builds a payment request for an amount
+	Link(method models.PaymentMethod, amount money.Money, reference string) (Link, error)
+}
+
+var providers = map[string]Provider{}
+
+// Register makes a provider available for its payment method type
+func Register(p Provider) {
+	providers[p.Type()] = p
+}
+
+func init() {
+	Register(MonzoProvider{})
+	Register(PayPalProvider{})
+	Register(RevolutProvider{})
+	Register(SEPAProvider{})
+	Register(UPIProvider{})
+}
+
+// Get returns the provider for a payment method type
+func Get(methodType string) (Provider, error) {
+	p, ok := providers[methodType]
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	return p, nil
+}
+
+// Types lists the supported payment method types
+func Types() []string {
+	types := make([]string, 0, len(providers))
+	for t := range providers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Accepts reports whether a provider can take payment in a currency
+func Accepts(p Provider, currency string) bool {
+	currencies := p.Currencies()
+	if currencies == nil {
+		return true
+	}
+	for _, c := range currencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported QR code format")
+
+// QR code output formats
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// QRProvider is implemented by providers whose payment requests can be
+// scanned from a QR code by a banking app
+type QRProvider interface {
+	Provider
+	// QRPayload returns the text to encode in the QR code
+	QRPayload(method models.PaymentMethod, amount money.Money, reference string) (string, error)
+}
+
+// QRCode renders a payload as a PNG or SVG image, returning the image and
+// its content type. Medium error correction is used, as EPC069-12 requires.
+func QRCode(payload, format string, size int) ([]byte, string, error) {
+	code, err := qrcode.New(payload, qrcode.Medium)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch format {
+	case FormatPNG, "":
+		png, err := code.PNG(size)
+		if err != nil {
+			return nil, "", err
+		}
+		return png, "image/png", nil
+	case FormatSVG:
+		return []byte(svg(code.Bitmap())), "image/svg+xml", nil
+	default:
+		return nil, "", ErrUnsupportedFormat
+	}
+}
+
+// svg draws a QR bitmap (which includes its quiet zone) as one path of unit squares
+func svg(bitmap [][]bool) string {
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	n := len(bitmap)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		n, n, n, n, path.String())
+}
@@ -0,0 +1,40 @@
+package payments
+
+import (
+	"fmt"
+	"net/url"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+// RevolutProvider builds revolut.me links. Revolut takes the amount in
+// minor units alongside its currency.
+type RevolutProvider struct{}
+
+func (RevolutProvider) Type() string { return TypeRevolut }
+
+func (RevolutProvider) Currencies() []string { return nil }
+
+func (RevolutProvider) Normalise(method models.PaymentMethod) (models.PaymentMethod, error) {
+	username, err := normaliseUsername(method.Handle, "revolut.me/", "www.revolut.me/")
+	if err != nil {
+		return method, err
+	}
+	method.Handle = username
+	return method, nil
+}
+
+func (RevolutProvider) Link(method models.PaymentMethod, amount money.Money, reference string) (Link, error) {
+	if amount.Amount <= 0 {
+		return Link{}, ErrUnsupportedAmount
+	}
+	query := url.Values{}
+	query.Set("amount", fmt.Sprint(amount.Amount))
+	query.Set("currency", amount.Currency)
+	if reference != "" {
+		query.Set("note", reference)
+	}
+	link := fmt.Sprintf("https://revolut.me/%s?%s", url.PathEscape(method.Handle), query.Encode())
+	return Link{Type: TypeRevolut, URL: link, Amount: amount, Reference: reference}, nil
+}
@@ -0,0 +1,139 @@
+package payments
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+var (
+	ErrInvalidIBAN       = errors.New("invalid IBAN")
+	ErrInvalidBIC        = errors.New("invalid BIC")
+	ErrEPCPayloadTooLong = errors.New("payment details too long for a SEPA QR code")
+
+	ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+	bicPattern  = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+)
+
+// SEPAProvider describes a SEPA credit transfer in euros. There is no link
+// format for bank transfers, so only the transfer details are returned.
+type SEPAProvider struct{}
+
+func (SEPAProvider) Type() string { return TypeSEPA }
+
+func (SEPAProvider) Currencies() []string { return []string{"EUR"} }
+
+func (SEPAProvider) Normalise(method models.PaymentMethod) (models.PaymentMethod, error) {
+	iban, err := NormaliseIBAN(method.Handle)
+	if err != nil {
+		return method, err
+	}
+	method.Handle = iban
+
+	method.BIC = strings.ToUpper(strings.ReplaceAll(method.BIC, " ", ""))
+	if method.BIC != "" && !bicPattern.MatchString(method.BIC) {
+		return method, ErrInvalidBIC
+	}
+
+	method.AccountName = strings.TrimSpace(method.AccountName)
+	if method.AccountName == "" {
+		return method, errors.New("account name is required for bank transfers")
+	}
+	return method, nil
+}
+
+func (SEPAProvider) Link(method models.PaymentMethod, amount money.Money, reference string) (Link, error) {
+	if amount.Currency != "EUR" || amount.Amount <= 0 {
+		return Link{}, ErrUnsupportedAmount
+	}
+	details := map[string]string{
+		"iban":         method.Handle,
+		"account_name": method.AccountName,
+		"amount":       amount.Decimal(),
+		"reference":    reference,
+	}
+	if method.BIC != "" {
+		details["bic"] = method.BIC
+	}
+	return Link{Type: TypeSEPA, Amount: amount, Reference: reference, Details: details}, nil
+}
+
+// NormaliseIBAN strips spaces from an IBAN, upper-cases it and checks its
+// ISO 13616 mod-97 checksum
+func NormaliseIBAN(iban string) (string, error) {
+	iban = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(iban), " ", ""))
+	if !ibanPattern.MatchString(iban) {
+		return "", ErrInvalidIBAN
+	}
+
+	// Move the country code and check digits to the end, then replace
+	// letters with two-digit numbers (A = 10 ... Z = 35)
+	var digits strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		if c >= 'A' && c <= 'Z' {
+			digits.WriteString(big.NewInt(int64(c-'A') + 10).String())
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+	n, _ := new(big.Int).SetString(digits.String(), 10)
+	if new(big.Int).Mod(n, big.NewInt(97)).Int64() != 1 {
+		return "", ErrInvalidIBAN
+	}
+	return iban, nil
+}
+
+// QRPayload builds an EPC069-12 (version 002) payload, the "GiroCode" that
+// European banking apps scan to pre-fill a SEPA credit transfer
+func (SEPAProvider) QRPayload(method models.PaymentMethod, amount money.Money, reference string) (string, error) {
+	return EPCPayload(method, amount, reference)
+}
+
+// epcMaxPayload is the most bytes an EPC069-12 payload may hold
+const epcMaxPayload = 331
+
+// EPCPayload builds an EPC069-12 SEPA credit transfer payload. Field limits
+// are applied in bytes, so multi-byte names and references can't push the
+// payload past what banking apps accept; the reference is cut further if
+// the whole payload would still be too long.
+func EPCPayload(method models.PaymentMethod, amount money.Money, reference string) (string, error) {
+	if amount.Currency != "EUR" || amount.Amount < 1 || amount.Amount > 99999999999 {
+		return "", ErrUnsupportedAmount
+	}
+
+	lines := []string{
+		"BCD",      // Service tag
+		"002",      // Version
+		"1",        // Character set: UTF-8
+		"SCT",      // SEPA credit transfer
+		method.BIC, // Optional within the EEA
+		truncate(method.AccountName, 70),
+		method.Handle, // IBAN
+		"EUR" + amount.Decimal(),
+		"", // Purpose code
+		"", // Structured reference
+		"", // Unstructured remittance information
+	}
+	room := epcMaxPayload - len(strings.Join(lines, "\n"))
+	if room < 0 {
+		return "", ErrEPCPayloadTooLong
+	}
+	lines[len(lines)-1] = truncate(reference, min(140, room))
+	return strings.Join(lines, "\n"), nil
+}
+
+// truncate shortens s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
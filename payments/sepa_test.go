@@ -0,0 +1,69 @@
+package payments
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+func TestEPCPayloadLength(t *testing.T) {
+	method := models.PaymentMethod{Type: TypeSEPA, Handle: "DE89370400440532013000", BIC: "COBADEFFXXX"}
+
+	tests := []struct {
+		name      string
+		account   string
+		reference string
+	}{
+		{"ascii", "Alice Example", "Dinner at Luigi's"},
+		{"long ascii", strings.Repeat("a", 100), strings.Repeat("r", 200)},
+		{"multi-byte reference", "Zoë Müller", strings.Repeat("€", 140)},
+		{"multi-byte everything", strings.Repeat("ü", 70), strings.Repeat("😀", 140)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method.AccountName = tt.account
+			payload, err := EPCPayload(method, money.New(123456, "EUR"), tt.reference)
+			if err != nil {
+				t.Fatalf("EPCPayload() error = %v", err)
+			}
+			if len(payload) > epcMaxPayload {
+				t.Errorf("payload is %d bytes, want at most %d", len(payload), epcMaxPayload)
+			}
+			if !utf8.ValidString(payload) {
+				t.Error("payload is not valid UTF-8")
+			}
+			lines := strings.Split(payload, "\n")
+			if len(lines) != 11 {
+				t.Fatalf("payload has %d lines, want 11", len(lines))
+			}
+			if !strings.HasPrefix(tt.reference, lines[10]) || lines[10] == "" {
+				t.Errorf("reference = %q, want a prefix of %q", lines[10], tt.reference)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"€€", 4, "€"},
+		{"€", 0, ""},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
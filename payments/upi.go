@@ -0,0 +1,68 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+var vpaPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{2,256}@[A-Za-z][A-Za-z0-9]{1,64}$`)
+
+// UPIProvider builds upi://pay deep links, which Indian banking apps open
+// directly. UPI only accepts INR.
+type UPIProvider struct{}
+
+func (UPIProvider) Type() string { return TypeUPI }
+
+func (UPIProvider) Currencies() []string { return []string{"INR"} }
+
+func (UPIProvider) Normalise(method models.PaymentMethod) (models.PaymentMethod, error) {
+	method.Handle = strings.TrimSpace(method.Handle)
+	if !vpaPattern.MatchString(method.Handle) {
+		return method, ErrInvalidHandle
+	}
+	method.AccountName = strings.TrimSpace(method.AccountName)
+	if method.AccountName == "" {
+		return method, errors.New("account name is required for UPI")
+	}
+	return method, nil
+}
+
+func (UPIProvider) Link(method models.PaymentMethod, amount money.Money, reference string) (Link, error) {
+	link, err := UPILink(method, amount, reference)
+	if err != nil {
+		return Link{}, err
+	}
+	return Link{Type: TypeUPI, URL: link, Amount: amount, Reference: reference}, nil
+}
+
+// UPILink builds a upi://pay URI following the NPCI linking specification
+func UPILink(method models.PaymentMethod, amount money.Money, reference string) (string, error) {
+	if amount.Currency != "INR" || amount.Amount <= 0 {
+		return "", ErrUnsupportedAmount
+	}
+	// The address is validated to URL-safe characters and is left unescaped,
+	// as some apps don't decode %40; other values use %20 rather than + for
+	// spaces for the same reason
+	link := fmt.Sprintf("upi://pay?pa=%s&pn=%s&am=%s&cu=%s",
+		method.Handle, upiEscape(method.AccountName), amount.Decimal(), amount.Currency)
+	if reference != "" {
+		link += "&tn=" + upiEscape(reference)
+	}
+	return link, nil
+}
+
+// upiEscape percent-encodes a query value, using %20 for spaces
+func upiEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// QRPayload returns the upi://pay URI, which UPI apps also accept as a QR code
+func (UPIProvider) QRPayload(method models.PaymentMethod, amount money.Money, reference string) (string, error) {
+	return UPILink(method, amount, reference)
+}
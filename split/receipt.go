@@ -0,0 +1,161 @@
+package split
+
+import (
+	"math/big"
+	"strings"
+
+	"receipt-splitter-backend/models"
+	"receipt-splitter-backend/money"
+)
+
+// deductionKeywords mark modifier types that reduce the bill
+var deductionKeywords = []string{"discount", "deduction", "voucher", "promo", "coupon", "refund", "off"}
+
+// Currency returns the receipt's currency, checking its items and modifiers
+// are all in it
+func Currency(receipt models.Receipt) (string, error) {
+	currency := receipt.Currency
+	check := func(m money.Money) error {
+		if currency == "" {
+			currency = m.Currency
+		} else if m.Currency != currency {
+			return money.ErrCurrencyMismatch
+		}
+		return nil
+	}
+	for _, item := range receipt.Items {
+		if err := check(item.Price); err != nil {
+			return "", err
+		}
+	}
+	for _, m := range receipt.Modifiers {
+		if err := check(m.Value); err != nil {
+			return "", err
+		}
+	}
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	return currency, nil
+}
+
+// Items builds split items from a receipt. assignments maps item IDs to the
+// people sharing that item; each person gets an equal portion of the line.
+func Items(receipt models.Receipt, assignments map[string][]string) []Item {
+	items := make([]Item, 0, len(receipt.Items))
+	for _, ri := range receipt.Items {
+		item := Item{ID: ri.ID, Amount: ri.Price.Amount * int64(ri.Qty)}
+		people := assignments[ri.ID]
+		for _, person := range people {
+			item.Claims = append(item.Claims, Claim{Person: person, Portion: big.NewRat(1, int64(len(people)))})
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// ClaimPortion returns the fraction of an item's line total covered by a claim
+func ClaimPortion(claim models.ItemClaim, item models.ReceiptItem) *big.Rat {
+	if item.Qty <= 0 || claim.Ways <= 0 {
+		return new(big.Rat)
+	}
+	return big.NewRat(int64(claim.Units), int64(claim.Ways)*int64(item.Qty))
+}
+
+// ClaimedItems builds split items from the claims participants have made,
+// keyed by participant ID
+func ClaimedItems(receipt models.Receipt, participants []models.Participant) []Item {
+	byItem := map[string][]models.ItemClaim{}
+	owner := map[string]string{}
+	for _, p := range participants {
+		for _, c := range p.Claims {
+			byItem[c.ReceiptItemID] = append(byItem[c.ReceiptItemID], c)
+			owner[c.ID] = p.ID
+		}
+	}
+
+	items := make([]Item, 0, len(receipt.Items))
+	for _, ri := range receipt.Items {
+		item := Item{ID: ri.ID, Amount: ri.Price.Amount * int64(ri.Qty)}
+		for _, c := range byItem[ri.ID] {
+			item.Claims = append(item.Claims, Claim{Person: owner[c.ID], Portion: ClaimPortion(c, ri)})
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Remaining returns how much of an item's quantity is still unclaimed
+func Remaining(item models.ReceiptItem, claims []models.ItemClaim) *big.Rat {
+	remaining := new(big.Rat).SetInt64(int64(item.Qty))
+	for _, c := range claims {
+		if c.Ways > 0 {
+			remaining.Sub(remaining, big.NewRat(int64(c.Units), int64(c.Ways)))
+		}
+	}
+	return remaining
+}
+
+// Adjustments converts a receipt's modifiers into signed adjustments.
+// Modifiers with Include unset are informational (e.g. VAT already in the
+// item prices) and are skipped. A percentage, when present, is applied to
+// the item subtotal; otherwise the absolute value is used.
+func Adjustments(receipt models.Receipt, items []Item) []Adjustment {
+	var subtotal int64
+	for _, item := range items {
+		subtotal += item.Amount
+	}
+
+	adjustments := make([]Adjustment, 0, len(receipt.Modifiers))
+	for _, m := range receipt.Modifiers {
+		if !m.Include {
+			continue
+		}
+
+		amount := m.Value.Abs().Amount
+		if m.Percentage != nil {
+			amount = m.Percentage.Of(subtotal)
+			if amount < 0 {
+				amount = -amount
+			}
+		}
+		if m.Value.Amount < 0 || IsDeduction(m.Type) {
+			amount = -amount
+		}
+
+		adjustments = append(adjustments, Adjustment{Name: m.Type, Amount: amount})
+	}
+	return adjustments
+}
+
+// IsDeduction reports whether a modifier type reduces the bill
+func IsDeduction(modifierType string) bool {
+	for _, word := range strings.Fields(strings.ToLower(modifierType)) {
+		for _, keyword := range deductionKeywords {
+			if word == keyword {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Receipt splits a receipt between the people assigned to its items
+func Receipt(receipt models.Receipt, assignments map[string][]string) (*Result, error) {
+	currency, err := Currency(receipt)
+	if err != nil {
+		return nil, err
+	}
+	items := Items(receipt, assignments)
+	return Calculate(currency, items, Adjustments(receipt, items))
+}
+
+// Claims splits a receipt between its participants according to their claims
+func Claims(receipt models.Receipt, participants []models.Participant) (*Result, error) {
+	currency, err := Currency(receipt)
+	if err != nil {
+		return nil, err
+	}
+	items := ClaimedItems(receipt, participants)
+	return Calculate(currency, items, Adjustments(receipt, items))
+}
@@ -0,0 +1,67 @@
+package split
+
+import "sort"
+
+// Transfer is a payment from one person to another
+type Transfer struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+}
+
+// Settle plans transfers that clear a set of balances, given as what each
+// person is owed (positive) or owes (negative) in minor units of a single
+// currency. Balances must add up to zero.
+//
+// It repeatedly has the person who owes the most pay the person owed the
+// most as much as clears one of them. Each transfer settles at least one
+// person, so there are fewer transfers than people with a balance, and
+// circular debts disappear. Ties go to the person whose name sorts first,
+// so the same balances always give the same plan.
+func Settle(balances map[string]int64) []Transfer {
+	var debtors, creditors []*balance
+	for person, amount := range balances {
+		switch {
+		case amount < 0:
+			debtors = append(debtors, &balance{person, -amount})
+		case amount > 0:
+			creditors = append(creditors, &balance{person, amount})
+		}
+	}
+
+	transfers := []Transfer{}
+	for {
+		debtor, creditor := largest(debtors), largest(creditors)
+		if debtor == nil || creditor == nil {
+			return transfers
+		}
+
+		amount := debtor.amount
+		if creditor.amount < amount {
+			amount = creditor.amount
+		}
+		transfers = append(transfers, Transfer{From: debtor.person, To: creditor.person, Amount: amount})
+		debtor.amount -= amount
+		creditor.amount -= amount
+	}
+}
+
+// balance is an amount a person owes or is owed, as a positive number
+type balance struct {
+	person string
+	amount int64
+}
+
+// largest returns the biggest outstanding balance, breaking ties by person
+func largest(balances []*balance) *balance {
+	sort.SliceStable(balances, func(i, j int) bool {
+		if balances[i].amount != balances[j].amount {
+			return balances[i].amount > balances[j].amount
+		}
+		return balances[i].person < balances[j].person
+	})
+	if len(balances) == 0 || balances[0].amount == 0 {
+		return nil
+	}
+	return balances[0]
+}
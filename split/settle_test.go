@@ -0,0 +1,84 @@
+package split
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[string]int64
+		want     []Transfer
+	}{
+		{
+			name:     "no balances",
+			balances: map[string]int64{},
+			want:     []Transfer{},
+		},
+		{
+			name:     "everyone settled",
+			balances: map[string]int64{"alice": 0, "bob": 0},
+			want:     []Transfer{},
+		},
+		{
+			name:     "one debt",
+			balances: map[string]int64{"alice": -500, "bob": 500},
+			want:     []Transfer{{From: "alice", To: "bob", Amount: 500}},
+		},
+		{
+			name:     "tied debtors pay in name order",
+			balances: map[string]int64{"bob": -100, "alice": -100, "carol": 200},
+			want: []Transfer{
+				{From: "alice", To: "carol", Amount: 100},
+				{From: "bob", To: "carol", Amount: 100},
+			},
+		},
+		{
+			name:     "tied creditors are paid in name order",
+			balances: map[string]int64{"alice": -200, "carol": 100, "bob": 100},
+			want: []Transfer{
+				{From: "alice", To: "bob", Amount: 100},
+				{From: "alice", To: "carol", Amount: 100},
+			},
+		},
+		{
+			name:     "largest debtor pays largest creditor first",
+			balances: map[string]int64{"alice": -300, "bob": -100, "carol": 250, "dave": 150},
+			want: []Transfer{
+				{From: "alice", To: "carol", Amount: 250},
+				{From: "bob", To: "dave", Amount: 100},
+				{From: "alice", To: "dave", Amount: 50},
+			},
+		},
+		{
+			name:     "settled people are left out",
+			balances: map[string]int64{"alice": -50, "bob": 0, "carol": 50},
+			want:     []Transfer{{From: "alice", To: "carol", Amount: 50}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Settle(tt.balances)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Settle() = %v, want %v", got, tt.want)
+			}
+
+			// Every plan must clear every balance
+			left := map[string]int64{}
+			for person, amount := range tt.balances {
+				left[person] = amount
+			}
+			for _, transfer := range got {
+				left[transfer.From] += transfer.Amount
+				left[transfer.To] -= transfer.Amount
+			}
+			for person, amount := range left {
+				if amount != 0 {
+					t.Errorf("%s left with %d", person, amount)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package split
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// Unassigned is the bucket name used for the part of a receipt nobody has claimed
+const Unassigned = ""
+
+// Item is a receipt line to be divided between people. Amounts throughout
+// this package are in minor units of a single currency.
+type Item struct {
+	ID     string
+	Amount int64 // Line total (price x qty) in minor units
+	Claims []Claim
+}
+
+// Claim assigns a portion of an item's line total to a person
+type Claim struct {
+	Person  string
+	Portion *big.Rat // Fraction of the line, between 0 and 1
+}
+
+// Adjustment is a receipt-wide modifier such as a service charge or discount
+type Adjustment struct {
+	Name   string
+	Amount int64 // Signed amount in minor units, negative for deductions
+}
+
+// Share is the amount owed by a single person
+type Share struct {
+	Person      string           `json:"person"`
+	Items       int64            `json:"items"`
+	Adjustments int64            `json:"adjustments"`
+	Total       int64            `json:"total"`
+	Lines       map[string]int64 `json:"lines"`
+}
+
+// Result is the outcome of splitting a receipt. All amounts are in minor
+// units of Currency.
+type Result struct {
+	Currency    string  `json:"currency"`
+	Subtotal    int64   `json:"subtotal"`
+	Adjustments int64   `json:"adjustments"`
+	Total       int64   `json:"total"`
+	Shares      []Share `json:"shares"`
+	Unassigned  Share   `json:"unassigned"`
+}
+
+var (
+	ErrInvalidPortion = errors.New("claim portion must be between 0 and 1")
+	ErrOverClaimed    = errors.New("item claimed more than once over")
+)
+
+// Calculate divides items and adjustments between the people claiming them.
+// Items are allocated by claimed portion; anything unclaimed goes to the
+// Unassigned share. Adjustments are spread in proportion to each share's
+// item subtotal. Every allocation uses largest-remainder rounding with ties
+// broken by person name, so the shares always add up to Result.Total.
+func Calculate(currency string, items []Item, adjustments []Adjustment) (*Result, error) {
+	shares := map[string]*Share{}
+	get := func(person string) *Share {
+		s, ok := shares[person]
+		if !ok {
+			s = &Share{Person: person, Lines: map[string]int64{}}
+			shares[person] = s
+		}
+		return s
+	}
+	get(Unassigned)
+
+	result := &Result{Currency: currency}
+
+	for _, item := range items {
+		weights := map[string]*big.Rat{}
+		claimed := new(big.Rat)
+		for _, c := range item.Claims {
+			if c.Portion == nil || c.Portion.Sign() < 0 || c.Portion.Cmp(big.NewRat(1, 1)) > 0 {
+				return nil, ErrInvalidPortion
+			}
+			if c.Portion.Sign() == 0 {
+				continue
+			}
+			if _, ok := weights[c.Person]; !ok {
+				weights[c.Person] = new(big.Rat)
+			}
+			weights[c.Person].Add(weights[c.Person], c.Portion)
+			claimed.Add(claimed, c.Portion)
+		}
+		if claimed.Cmp(big.NewRat(1, 1)) > 0 {
+			return nil, ErrOverClaimed
+		}
+		if rest := new(big.Rat).Sub(big.NewRat(1, 1), claimed); rest.Sign() > 0 {
+			weights[Unassigned] = rest
+		}
+
+		for person, amount := range allocate(item.Amount, weights) {
+			s := get(person)
+			s.Items += amount
+			s.Lines[item.ID] += amount
+		}
+		result.Subtotal += item.Amount
+	}
+
+	// Spread adjustments by item subtotal; with nothing to weight by they
+	// stay unassigned
+	weights := map[string]*big.Rat{}
+	for person, s := range shares {
+		if s.Items != 0 {
+			weights[person] = new(big.Rat).SetInt64(s.Items)
+		}
+	}
+	if len(weights) == 0 {
+		weights[Unassigned] = big.NewRat(1, 1)
+	}
+	for _, adj := range adjustments {
+		for person, amount := range allocate(adj.Amount, weights) {
+			get(person).Adjustments += amount
+		}
+		result.Adjustments += adj.Amount
+	}
+
+	result.Total = result.Subtotal + result.Adjustments
+
+	for person, s := range shares {
+		s.Total = s.Items + s.Adjustments
+		if person == Unassigned {
+			result.Unassigned = *s
+			continue
+		}
+		result.Shares = append(result.Shares, *s)
+	}
+	sort.Slice(result.Shares, func(i, j int) bool {
+		return result.Shares[i].Person < result.Shares[j].Person
+	})
+
+	return result, nil
+}
+
+// allocate divides amount between keys in proportion to their weights using
+// the largest-remainder method. Leftover units go to the largest fractional
+// parts first, then to keys in name order, so the result is deterministic
+// and always sums to amount.
+func allocate(amount int64, weights map[string]*big.Rat) map[string]int64 {
+	out := map[string]int64{}
+	if len(weights) == 0 {
+		return out
+	}
+
+	total := new(big.Rat)
+	keys := make([]string, 0, len(weights))
+	for k, w := range weights {
+		total.Add(total, w)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if total.Sign() == 0 {
+		return out
+	}
+
+	sign := int64(1)
+	if amount < 0 {
+		sign, amount = -1, -amount
+	}
+
+	type part struct {
+		key       string
+		remainder *big.Rat
+	}
+	parts := make([]part, 0, len(keys))
+	var assigned int64
+	for _, k := range keys {
+		exact := new(big.Rat).Mul(new(big.Rat).SetInt64(amount), weights[k])
+		exact.Quo(exact, total)
+		floor := new(big.Int).Quo(exact.Num(), exact.Denom())
+		out[k] = floor.Int64()
+		assigned += out[k]
+		parts = append(parts, part{k, new(big.Rat).Sub(exact, new(big.Rat).SetInt(floor))})
+	}
+
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].remainder.Cmp(parts[j].remainder) > 0
+	})
+	for i := int64(0); i < amount-assigned; i++ {
+		out[parts[i].key]++
+	}
+
+	for k := range out {
+		out[k] *= sign
+	}
+	return out
+}
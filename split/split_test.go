@@ -0,0 +1,214 @@
+package split
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	third := big.NewRat(1, 3)
+	half := big.NewRat(1, 2)
+	whole := big.NewRat(1, 1)
+
+	tests := []struct {
+		name        string
+		items       []Item
+		adjustments []Adjustment
+		// totals is each person's total, with "" for the unassigned share
+		totals map[string]int64
+		total  int64
+	}{
+		{
+			name:   "single claimant takes the whole line",
+			items:  []Item{{ID: "i1", Amount: 1000, Claims: []Claim{{"alice", whole}}}},
+			totals: map[string]int64{"alice": 1000, Unassigned: 0},
+			total:  1000,
+		},
+		{
+			name: "leftover unit goes to the first name on a tie",
+			items: []Item{{ID: "i1", Amount: 1000, Claims: []Claim{
+				{"carol", third}, {"bob", third}, {"alice", third},
+			}}},
+			totals: map[string]int64{"alice": 334, "bob": 333, "carol": 333, Unassigned: 0},
+			total:  1000,
+		},
+		{
+			name: "leftover units go to the largest remainders first",
+			items: []Item{{ID: "i1", Amount: 100, Claims: []Claim{
+				{"alice", big.NewRat(1, 6)}, {"bob", big.NewRat(5, 6)},
+			}}},
+			// 16.67 and 83.33: bob's remainder is smaller, so alice rounds up
+			totals: map[string]int64{"alice": 17, "bob": 83, Unassigned: 0},
+			total:  100,
+		},
+		{
+			name:  "unclaimed portion is unassigned",
+			items: []Item{{ID: "i1", Amount: 999, Claims: []Claim{{"alice", half}}}},
+			// The unassigned share's empty name sorts first, so it wins the tie
+			totals: map[string]int64{"alice": 499, Unassigned: 500},
+			total:  999,
+		},
+		{
+			name:   "claims by the same person add up",
+			items:  []Item{{ID: "i1", Amount: 600, Claims: []Claim{{"alice", third}, {"alice", third}, {"bob", third}}}},
+			totals: map[string]int64{"alice": 400, "bob": 200, Unassigned: 0},
+			total:  600,
+		},
+		{
+			name: "adjustments follow item subtotals",
+			items: []Item{
+				{ID: "i1", Amount: 1000, Claims: []Claim{{"alice", whole}}},
+				{ID: "i2", Amount: 3000, Claims: []Claim{{"bob", whole}}},
+			},
+			adjustments: []Adjustment{{Name: "service", Amount: 100}},
+			totals:      map[string]int64{"alice": 1025, "bob": 3075, Unassigned: 0},
+			total:       4100,
+		},
+		{
+			name: "deductions round like charges",
+			items: []Item{
+				{ID: "i1", Amount: 500, Claims: []Claim{{"alice", whole}}},
+				{ID: "i2", Amount: 500, Claims: []Claim{{"bob", whole}}},
+				{ID: "i3", Amount: 500, Claims: []Claim{{"carol", whole}}},
+			},
+			adjustments: []Adjustment{{Name: "discount", Amount: -10}},
+			totals:      map[string]int64{"alice": 496, "bob": 497, "carol": 497, Unassigned: 0},
+			total:       1490,
+		},
+		{
+			name:        "adjustments with no items stay unassigned",
+			adjustments: []Adjustment{{Name: "service", Amount: 250}},
+			totals:      map[string]int64{Unassigned: 250},
+			total:       250,
+		},
+		{
+			name: "zero portions are ignored",
+			items: []Item{{ID: "i1", Amount: 100, Claims: []Claim{
+				{"alice", whole}, {"bob", new(big.Rat)},
+			}}},
+			totals: map[string]int64{"alice": 100, Unassigned: 0},
+			total:  100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Calculate("GBP", tt.items, tt.adjustments)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+
+			totals := map[string]int64{Unassigned: result.Unassigned.Total}
+			sum := result.Unassigned.Total
+			for _, s := range result.Shares {
+				totals[s.Person] = s.Total
+				sum += s.Total
+			}
+			if !reflect.DeepEqual(totals, tt.totals) {
+				t.Errorf("totals = %v, want %v", totals, tt.totals)
+			}
+			if result.Total != tt.total {
+				t.Errorf("Total = %d, want %d", result.Total, tt.total)
+			}
+			if sum != result.Total {
+				t.Errorf("shares add up to %d, want %d", sum, result.Total)
+			}
+		})
+	}
+}
+
+func TestCalculateSortsShares(t *testing.T) {
+	items := []Item{{ID: "i1", Amount: 300, Claims: []Claim{
+		{"carol", big.NewRat(1, 3)}, {"alice", big.NewRat(1, 3)}, {"bob", big.NewRat(1, 3)},
+	}}}
+	result, err := Calculate("GBP", items, nil)
+	if err != nil {
+		t.Fatalf("Calculate() error = %v", err)
+	}
+
+	var people []string
+	for _, s := range result.Shares {
+		people = append(people, s.Person)
+	}
+	if want := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(people, want) {
+		t.Errorf("shares in order %v, want %v", people, want)
+	}
+}
+
+func TestCalculateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims []Claim
+		want   error
+	}{
+		{"nil portion", []Claim{{"alice", nil}}, ErrInvalidPortion},
+		{"negative portion", []Claim{{"alice", big.NewRat(-1, 2)}}, ErrInvalidPortion},
+		{"portion over one", []Claim{{"alice", big.NewRat(3, 2)}}, ErrInvalidPortion},
+		{"claimed more than once over", []Claim{{"alice", big.NewRat(1, 2)}, {"bob", big.NewRat(2, 3)}}, ErrOverClaimed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Calculate("GBP", []Item{{ID: "i1", Amount: 100, Claims: tt.claims}}, nil)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Calculate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights map[string]*big.Rat
+		want    map[string]int64
+	}{
+		{
+			name:    "exact split",
+			amount:  100,
+			weights: map[string]*big.Rat{"a": big.NewRat(1, 1), "b": big.NewRat(1, 1)},
+			want:    map[string]int64{"a": 50, "b": 50},
+		},
+		{
+			name:    "ties go to names in order",
+			amount:  2,
+			weights: map[string]*big.Rat{"c": big.NewRat(1, 1), "b": big.NewRat(1, 1), "a": big.NewRat(1, 1)},
+			want:    map[string]int64{"a": 1, "b": 1, "c": 0},
+		},
+		{
+			name:    "negative amounts mirror positive ones",
+			amount:  -2,
+			weights: map[string]*big.Rat{"c": big.NewRat(1, 1), "b": big.NewRat(1, 1), "a": big.NewRat(1, 1)},
+			want:    map[string]int64{"a": -1, "b": -1, "c": 0},
+		},
+		{
+			name:    "largest remainder wins over name order",
+			amount:  10,
+			weights: map[string]*big.Rat{"a": big.NewRat(1, 1), "b": big.NewRat(2, 1)},
+			want:    map[string]int64{"a": 3, "b": 7},
+		},
+		{
+			name:    "no weights",
+			amount:  100,
+			weights: map[string]*big.Rat{},
+			want:    map[string]int64{},
+		},
+		{
+			name:    "zero total weight",
+			amount:  100,
+			weights: map[string]*big.Rat{"a": new(big.Rat)},
+			want:    map[string]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allocate(tt.amount, tt.weights); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocate(%d) = %v, want %v", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
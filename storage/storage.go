@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs such as receipt images under string keys
+type Store interface {
+	// Put saves a blob, replacing any stored under the same key
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Get returns a blob and its content type, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, string, error)
+	// Delete removes a blob; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Config selects and configures a blob store
+type Config struct {
+	// Driver is "local" (the default) or "s3"
+	Driver string
+	// Path is the local store's root directory
+	Path string
+
+	// The S3 settings work with AWS and S3-compatible servers such as MinIO.
+	// Endpoint is a host[:port], e.g. "s3.amazonaws.com" or "localhost:9000".
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Insecure connects over plain HTTP, e.g. to a local MinIO
+	Insecure bool
+}
+
+// NewStore creates the store named in cfg
+func NewStore(cfg Config) (Store, error) {
+	switch cfg.Driver {
+	case "", "local":
+		path := cfg.Path
+		if path == "" {
+			path = "data/blobs"
+		}
+		return NewLocalStore(path)
+	case "s3":
+		return NewS3Store(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}